@@ -0,0 +1,698 @@
+package genx
+
+import (
+	"bytes"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// pruneBranches removes the if, switch and type switch branches of the rewritten file src that can't run anymore
+// now that the placeholders are replaced (ex: `if intrinsics.IsBuiltin("T")` or `switch any(zero).(type)`),
+// fixed has the names of the constants that don't depend on them by line, the branches they decide are left alone.
+// It returns false if there's nothing to remove.
+func pruneBranches(name string, src []byte, fixed map[int]map[string]bool) ([]byte, bool) {
+	skip := map[int]bool{}
+	for {
+		out, unused, ok := pruneDecls(name, src, fixed, skip)
+		if !ok || len(unused) == 0 {
+			return out, ok
+		}
+		// the removed branches were the only ones to use a variable, the declarations it's in are left as is.
+		for _, i := range unused {
+			skip[i] = true
+		}
+	}
+}
+
+// pruneDecls prunes the branches of the declarations of src that aren't in skip, it returns the ones that have
+// unused variables or labels after that, the unused imports are left to goimports.
+func pruneDecls(name string, src []byte, fixed map[int]map[string]bool, skip map[int]bool) (_ []byte, unused []int, _ bool) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, name, src, parser.ParseComments)
+	if err != nil {
+		return src, nil, false
+	}
+
+	bp := &branchPruner{
+		fset:  fset,
+		fixed: fixed,
+		info: &types.Info{
+			Types:     map[ast.Expr]types.TypeAndValue{},
+			Defs:      map[*ast.Ident]types.Object{},
+			Uses:      map[*ast.Ident]types.Object{},
+			Implicits: map[ast.Node]types.Object{},
+			Scopes:    map[ast.Node]*types.Scope{},
+		},
+		used: map[types.Object]bool{},
+	}
+
+	before := checkUnused(fset, f, bp.info)
+	for _, obj := range bp.info.Uses {
+		bp.used[obj] = true
+	}
+
+	cmap := ast.NewCommentMap(fset, f, f.Comments)
+	for i, d := range f.Decls {
+		if !skip[i] {
+			ast.Inspect(d, bp.visit)
+		}
+	}
+	if !bp.changed {
+		return src, nil, false
+	}
+	f.Comments = cmap.Filter(f).Comments()
+	removeLines(fset.File(f.Pos()), f, src)
+
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, f); err != nil {
+		return src, nil, false
+	}
+
+	fset = token.NewFileSet()
+	if f, err = parser.ParseFile(fset, name, buf.Bytes(), 0); err != nil {
+		return src, nil, false
+	}
+	for i, n := range checkUnused(fset, f, nil) {
+		if n > before[i] {
+			unused = append(unused, i)
+		}
+	}
+	return buf.Bytes(), unused, true
+}
+
+// checkUnused type-checks f and returns how many unused variables and labels each of its declarations has,
+// the rest of the package isn't rewritten yet so any other error is ignored.
+func checkUnused(fset *token.FileSet, f *ast.File, info *types.Info) map[int]int {
+	unused := map[int]int{}
+	conf := types.Config{
+		Importer: sharedImporter{},
+		Error: func(err error) {
+			te, ok := err.(types.Error)
+			if !ok || !strings.Contains(te.Msg, "declared and not used") {
+				return
+			}
+			if i := sort.Search(len(f.Decls), func(i int) bool { return f.Decls[i].End() > te.Pos }); i < len(f.Decls) {
+				unused[i]++
+			}
+		},
+	}
+	conf.Check(f.Name.Name, fset, []*ast.File{f}, info)
+	return unused
+}
+
+// fixedConsts returns the constants whose value doesn't depend on the placeholders, the value: rewriters or the
+// intrinsics (ex: const debug = false), pruneBranches leaves the branches they decide alone.
+func (g *GenX) fixedConsts(files []*ast.File) map[types.Object]bool {
+	fixed := map[types.Object]bool{}
+	if g.pkg == nil {
+		return fixed
+	}
+
+	isFixed := func(x ast.Node) (ok bool) {
+		ok = true
+		ast.Inspect(x, func(n ast.Node) bool {
+			id, isIdent := n.(*ast.Ident)
+			if !isIdent || !ok {
+				return ok
+			}
+			switch obj := g.info.Uses[id].(type) {
+			case *types.Const:
+				ok = obj.Pkg() != g.pkg || fixed[obj]
+			case *types.TypeName:
+				ok = obj.Pkg() != g.pkg
+			case *types.PkgName:
+				ok = obj.Imported().Path() != intrinsicsPath
+			case *types.Builtin, *types.Nil:
+			default:
+				ok = false
+			}
+			return ok
+		})
+		return
+	}
+
+	// constants can be declared after the ones that use them.
+	for changed := true; changed; {
+		changed = false
+		for _, f := range files {
+			ast.Inspect(f, func(n ast.Node) bool {
+				gd, ok := n.(*ast.GenDecl)
+				if !ok || gd.Tok != token.CONST {
+					return true
+				}
+				var (
+					typ    ast.Expr
+					values []ast.Expr
+				)
+				for _, s := range gd.Specs {
+					vs := s.(*ast.ValueSpec)
+					if vs.Values != nil {
+						// the specs without values repeat the ones before them.
+						typ, values = vs.Type, vs.Values
+					}
+					for i, id := range vs.Names {
+						c := g.info.Defs[id]
+						if c == nil || fixed[c] || g.constVals[c] != nil || i >= len(values) {
+							continue
+						}
+						if (typ == nil || isFixed(typ)) && isFixed(values[i]) {
+							fixed[c], changed = true, true
+						}
+					}
+				}
+				return false
+			})
+		}
+	}
+	return fixed
+}
+
+// fixedNames returns the names of src, the output of file, that refer to fixed constants or to the true and false
+// written in the template, by line.
+func (g *GenX) fixedNames(fset *token.FileSet, file *ast.File, name string, src []byte) map[int]map[string]bool {
+	byLine := map[int]map[string]bool{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok || !id.Pos().IsValid() {
+			return true
+		}
+		// the ones the rewriters add (ex: intrinsics.IsBuiltin) don't have a position.
+		if c, ok := g.info.Uses[id].(*types.Const); ok && (g.fixed[c] || c.Parent() == types.Universe) {
+			line := fset.PositionFor(id.Pos(), false).Line
+			if byLine[line] == nil {
+				byLine[line] = map[string]bool{}
+			}
+			byLine[line][id.Name] = true
+		}
+		return true
+	})
+	if len(byLine) == 0 {
+		return nil
+	}
+
+	out := map[int]map[string]bool{}
+	for line, pos := range mapLines(fset, []*ast.File{file}, name, src, false) {
+		if names := byLine[pos.Line]; names != nil {
+			out[line] = names
+		}
+	}
+	return out
+}
+
+type branchPruner struct {
+	fset    *token.FileSet
+	fixed   map[int]map[string]bool
+	info    *types.Info
+	used    map[types.Object]bool
+	changed bool
+}
+
+// branchState is whether a branch always runs, never runs or depends on values only known at runtime.
+type branchState int
+
+const (
+	branchMaybe branchState = iota
+	branchAlways
+	branchNever
+)
+
+func (bp *branchPruner) visit(n ast.Node) bool {
+	switch n := n.(type) {
+	case *ast.FuncDecl:
+		if n.Body != nil {
+			// function bodies share the scope of their signature.
+			bp.info.Scopes[n.Body] = bp.info.Scopes[n.Type]
+		}
+	case *ast.FuncLit:
+		bp.info.Scopes[n.Body] = bp.info.Scopes[n.Type]
+	case *ast.BlockStmt:
+		n.List = bp.pruneList(bp.info.Scopes[n], n.List)
+	case *ast.CaseClause:
+		n.Body = bp.pruneList(bp.info.Scopes[n], n.Body)
+	case *ast.CommClause:
+		n.Body = bp.pruneList(bp.info.Scopes[n], n.Body)
+	}
+	return true
+}
+
+// pruneList replaces the statements of list that have branches that never run with the ones that do.
+func (bp *branchPruner) pruneList(scope *types.Scope, list []ast.Stmt) []ast.Stmt {
+	var out []ast.Stmt
+	for i, s := range list {
+		stmts, ok := bp.pruneStmt(s)
+		if !ok {
+			if out != nil {
+				out = append(out, s)
+			}
+			continue
+		}
+		if out == nil {
+			out = append(make([]ast.Stmt, 0, len(list)), list[:i]...)
+		}
+		bp.changed = true
+		stmts = bp.splice(scope, stmts)
+		out = append(out, stmts...)
+
+		// what follows a branch that always returns can't run anymore, unless it's the target of a goto.
+		if len(stmts) > 0 && bp.terminates(stmts[len(stmts)-1]) && !hasLabels(list[i+1:]) {
+			return out
+		}
+	}
+	if out == nil {
+		return list
+	}
+	return out
+}
+
+// splice returns stmts as is if they can be moved to the enclosing block, or wrapped in a block if they declare
+// anything that could conflict with or shadow what's already there.
+func (bp *branchPruner) splice(scope *types.Scope, stmts []ast.Stmt) []ast.Stmt {
+	for _, s := range stmts {
+		var names []*ast.Ident
+		switch s := s.(type) {
+		case *ast.BlockStmt:
+			continue
+		case *ast.AssignStmt:
+			if s.Tok == token.DEFINE {
+				for _, x := range s.Lhs {
+					if id, ok := x.(*ast.Ident); ok {
+						names = append(names, id)
+					}
+				}
+			}
+		case *ast.DeclStmt:
+			ast.Inspect(s, func(n ast.Node) bool {
+				if id, ok := n.(*ast.Ident); ok && bp.info.Defs[id] != nil {
+					names = append(names, id)
+				}
+				return true
+			})
+		}
+		for _, id := range names {
+			if id.Name == "_" {
+				continue
+			}
+			if scope == nil {
+				return []ast.Stmt{&ast.BlockStmt{List: stmts}}
+			}
+			if _, obj := scope.LookupParent(id.Name, token.NoPos); obj != nil {
+				return []ast.Stmt{&ast.BlockStmt{List: stmts}}
+			}
+		}
+	}
+	return stmts
+}
+
+// pruneStmt returns what s should be replaced with and true if any of its branches never run.
+func (bp *branchPruner) pruneStmt(s ast.Stmt) ([]ast.Stmt, bool) {
+	switch s := s.(type) {
+	case *ast.IfStmt:
+		return bp.pruneIf(s)
+	case *ast.SwitchStmt:
+		if s.Tag == nil {
+			for _, cc := range s.Body.List {
+				cc := cc.(*ast.CaseClause)
+				for i, x := range cc.List {
+					cc.List[i] = bp.foldCond(x)
+				}
+			}
+		}
+		taken, changed := selectClause(s.Body, func(x ast.Expr) branchState {
+			return bp.caseState(s.Tag, x)
+		})
+		return bp.replaceSwitch(s, s.Init, s.Body, taken, nil, changed)
+	case *ast.TypeSwitchStmt:
+		return bp.pruneTypeSwitch(s)
+	}
+	return nil, false
+}
+
+func (bp *branchPruner) pruneIf(s *ast.IfStmt) ([]ast.Stmt, bool) {
+	s.Cond = bp.foldCond(s.Cond)
+	v, ok := bp.boolValue(s.Cond)
+	if !ok {
+		// if x {} else if false {}
+		if elif, ok := s.Else.(*ast.IfStmt); ok {
+			if stmts, ok := bp.pruneIf(elif); ok {
+				switch {
+				case len(stmts) == 0:
+					s.Else = nil
+				case len(stmts) == 1 && isIfOrBlock(stmts[0]):
+					s.Else = stmts[0]
+				default:
+					s.Else = &ast.BlockStmt{List: stmts}
+				}
+				return []ast.Stmt{s}, true
+			}
+		}
+		return nil, false
+	}
+
+	taken := ast.Stmt(s.Body)
+	if !v {
+		taken = s.Else
+	}
+
+	var stmts []ast.Stmt
+	switch taken := taken.(type) {
+	case *ast.BlockStmt:
+		stmts = taken.List
+	case *ast.IfStmt:
+		if stmts, ok = bp.pruneIf(taken); !ok {
+			stmts = []ast.Stmt{taken}
+		}
+	}
+	return withInit(s.Init, stmts), true
+}
+
+func (bp *branchPruner) pruneTypeSwitch(s *ast.TypeSwitchStmt) ([]ast.Stmt, bool) {
+	var (
+		x  ast.Expr
+		id *ast.Ident
+	)
+	switch a := s.Assign.(type) {
+	case *ast.AssignStmt:
+		id, x = a.Lhs[0].(*ast.Ident), a.Rhs[0].(*ast.TypeAssertExpr).X
+	case *ast.ExprStmt:
+		x = a.X.(*ast.TypeAssertExpr).X
+	}
+
+	// any(v) where v isn't an interface has v's type.
+	inner, dyn := x, bp.info.TypeOf(x)
+	if call, ok := x.(*ast.CallExpr); ok && len(call.Args) == 1 && bp.info.Types[call.Fun].IsType() {
+		inner, dyn = call.Args[0], bp.info.TypeOf(call.Args[0])
+	}
+	if dyn == nil || dyn == types.Typ[types.Invalid] || types.IsInterface(dyn) {
+		return nil, false
+	}
+	dyn = types.Default(dyn)
+
+	taken, changed := selectClause(s.Body, func(x ast.Expr) branchState {
+		tv, ok := bp.info.Types[x]
+		switch {
+		case !ok:
+			return branchMaybe
+		case tv.IsNil():
+			return branchNever
+		case !tv.IsType() || tv.Type == types.Typ[types.Invalid]:
+			return branchMaybe
+		case types.IsInterface(tv.Type):
+			if types.Implements(dyn, tv.Type.Underlying().(*types.Interface)) {
+				return branchAlways
+			}
+		case types.Identical(dyn, tv.Type):
+			return branchAlways
+		}
+		return branchNever
+	})
+
+	// the clause's variable has the case's type if it only has one, the type of x otherwise.
+	var decl ast.Stmt
+	if taken != nil && id != nil && bp.used[bp.info.Implicits[taken]] {
+		switch {
+		case len(taken.List) != 1:
+			decl = &ast.AssignStmt{Lhs: []ast.Expr{id}, Tok: token.DEFINE, Rhs: []ast.Expr{x}}
+		case types.Identical(dyn, bp.info.TypeOf(taken.List[0])):
+			if inner, ok := inner.(*ast.Ident); ok && inner.Name == id.Name {
+				// switch v := any(v).(type)
+				break
+			}
+			decl = &ast.AssignStmt{Lhs: []ast.Expr{id}, Tok: token.DEFINE, Rhs: []ast.Expr{inner}}
+		default:
+			decl = &ast.DeclStmt{Decl: &ast.GenDecl{Tok: token.VAR, Specs: []ast.Spec{
+				&ast.ValueSpec{Names: []*ast.Ident{id}, Type: ast.NewIdent(types.ExprString(taken.List[0])), Values: []ast.Expr{inner}},
+			}}}
+		}
+	}
+	return bp.replaceSwitch(s, s.Init, s.Body, taken, decl, changed)
+}
+
+// replaceSwitch returns what's left of the switch s once the clauses that never run are removed,
+// the statements of taken replace it if it's the only one that can run, preceded by decl if it's set.
+func (bp *branchPruner) replaceSwitch(s, init ast.Stmt, body *ast.BlockStmt, taken *ast.CaseClause, decl ast.Stmt, changed bool) ([]ast.Stmt, bool) {
+	switch {
+	case taken != nil && !breaksOut(taken.Body):
+		stmts := taken.Body
+		if decl != nil {
+			stmts = append([]ast.Stmt{decl}, stmts...)
+		}
+		return withInit(init, stmts), true
+	case len(body.List) == 0:
+		return withInit(init, nil), true
+	case changed:
+		return []ast.Stmt{s}, true
+	}
+	return nil, false
+}
+
+// selectClause removes the clauses of body that never run, state reports whether a case expression matches.
+// It returns the clause that always runs if there's one, and whether any clause was removed.
+func selectClause(body *ast.BlockStmt, state func(ast.Expr) branchState) (taken *ast.CaseClause, changed bool) {
+	var (
+		def    *ast.CaseClause
+		maybe  bool
+		always bool
+	)
+	drop := map[ast.Stmt]bool{}
+	for _, s := range body.List {
+		cc := s.(*ast.CaseClause)
+		switch {
+		case always:
+			// nothing after a case that always matches can run.
+			drop[cc] = true
+			continue
+		case cc.List == nil:
+			def = cc
+			continue
+		}
+
+		cs := branchNever
+		for _, x := range cc.List {
+			if st := state(x); st == branchAlways {
+				cs = branchAlways
+				break
+			} else if st == branchMaybe {
+				cs = branchMaybe
+			}
+		}
+
+		switch cs {
+		case branchNever:
+			drop[cc] = true
+		case branchAlways:
+			if always = true; !maybe {
+				taken = cc
+			}
+		default:
+			maybe = true
+		}
+	}
+
+	if def != nil {
+		if always {
+			drop[def] = true
+		} else if !maybe {
+			taken = def
+		}
+	}
+
+	if len(drop) == 0 {
+		return taken, false
+	}
+	clauses := body.List[:0]
+	for _, s := range body.List {
+		if !drop[s] {
+			clauses = append(clauses, s)
+		}
+	}
+	body.List = clauses
+	return taken, true
+}
+
+// caseState reports whether the case x of a switch on tag matches, a nil tag is a switch on true.
+func (bp *branchPruner) caseState(tag, x ast.Expr) branchState {
+	tv := bp.info.Types[x]
+	if tag == nil {
+		v, ok := bp.boolValue(x)
+		switch {
+		case !ok:
+			return branchMaybe
+		case v:
+			return branchAlways
+		}
+		return branchNever
+	}
+
+	ttv := bp.info.Types[tag]
+	if bp.isFixed(tag) || bp.isFixed(x) || tv.Value == nil || ttv.Value == nil || tv.Value.Kind() != ttv.Value.Kind() {
+		return branchMaybe
+	}
+	if constant.Compare(ttv.Value, token.EQL, tv.Value) {
+		return branchAlways
+	}
+	return branchNever
+}
+
+// foldCond simplifies the && and || of x that have a constant left side, or a right side that doesn't change the result.
+func (bp *branchPruner) foldCond(x ast.Expr) ast.Expr {
+	switch b := x.(type) {
+	case *ast.ParenExpr:
+		if inner := bp.foldCond(b.X); inner != b.X {
+			if _, ok := bp.boolValue(inner); ok {
+				bp.changed = true
+				return inner
+			}
+			b.X = inner
+		}
+	case *ast.BinaryExpr:
+		if b.Op != token.LAND && b.Op != token.LOR {
+			break
+		}
+		b.X, b.Y = bp.foldCond(b.X), bp.foldCond(b.Y)
+		short := b.Op == token.LOR // the value that makes the other side irrelevant
+		if v, ok := bp.boolValue(b.X); ok {
+			bp.changed = true
+			if v == short {
+				return b.X
+			}
+			return b.Y
+		}
+		if v, ok := bp.boolValue(b.Y); ok && v != short {
+			bp.changed = true
+			return b.X
+		}
+	}
+	return x
+}
+
+// boolValue returns the value of x if it's a boolean constant that depends on the placeholders.
+func (bp *branchPruner) boolValue(x ast.Expr) (v, ok bool) {
+	if bp.isFixed(x) {
+		return false, false
+	}
+	if id, ok := x.(*ast.Ident); ok && bp.info.Uses[id] != nil && bp.info.Uses[id].Parent() == types.Universe {
+		switch id.Name {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		}
+	}
+	tv := bp.info.Types[x]
+	if tv.Value == nil || tv.Value.Kind() != constant.Bool {
+		return false, false
+	}
+	return constant.BoolVal(tv.Value), true
+}
+
+// isFixed reports whether x uses a constant that doesn't depend on the placeholders.
+func (bp *branchPruner) isFixed(x ast.Node) (found bool) {
+	if len(bp.fixed) == 0 {
+		return false
+	}
+	ast.Inspect(x, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && !found {
+			found = bp.fixed[bp.fset.Position(id.Pos()).Line][id.Name]
+		}
+		return !found
+	})
+	return
+}
+
+// withInit returns stmts preceded by the init statement of the if or switch they came from, in their own block
+// since the init's declarations were scoped to it.
+func withInit(init ast.Stmt, stmts []ast.Stmt) []ast.Stmt {
+	if init == nil {
+		return stmts
+	}
+	return []ast.Stmt{&ast.BlockStmt{List: append([]ast.Stmt{init}, stmts...)}}
+}
+
+// breaksOut reports whether stmts use break or fallthrough for the switch they're in.
+func breaksOut(stmts []ast.Stmt) (found bool) {
+	for _, s := range stmts {
+		ast.Inspect(s, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt, *ast.FuncLit:
+				return false
+			case *ast.BranchStmt:
+				found = found || (n.Tok == token.BREAK && n.Label == nil) || n.Tok == token.FALLTHROUGH
+			}
+			return !found
+		})
+	}
+	return
+}
+
+// terminates reports whether s is a return or a call to panic.
+func (bp *branchPruner) terminates(s ast.Stmt) bool {
+	switch s := s.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.ExprStmt:
+		if call, ok := s.X.(*ast.CallExpr); ok {
+			id, ok := call.Fun.(*ast.Ident)
+			return ok && id.Name == "panic" && bp.info.Uses[id] == types.Universe.Lookup("panic")
+		}
+	}
+	return false
+}
+
+func hasLabels(stmts []ast.Stmt) bool {
+	for _, s := range stmts {
+		if _, ok := s.(*ast.LabeledStmt); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// removeLines removes the lines of src that only had code that was removed from f,
+// otherwise the printer leaves blank lines in their place.
+func removeLines(tf *token.File, f *ast.File, src []byte) {
+	kept := map[int]bool{}
+	keep := func(from, to token.Pos) {
+		if !from.IsValid() || !to.IsValid() {
+			return
+		}
+		for l := tf.Line(from); l <= tf.Line(to); l++ {
+			kept[l] = true
+		}
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if n == nil || !n.Pos().IsValid() {
+			return true
+		}
+		if lit, ok := n.(*ast.BasicLit); ok {
+			// multiline raw strings
+			keep(lit.Pos(), lit.End())
+		}
+		keep(n.Pos(), n.Pos())
+		keep(n.End()-1, n.End()-1)
+		return true
+	})
+	for _, cg := range f.Comments {
+		keep(cg.Pos(), cg.End())
+	}
+
+	lines := bytes.Split(src, []byte("\n"))
+	for l := len(lines); l > 1; l-- {
+		if l < tf.LineCount() && !kept[l] && len(bytes.TrimSpace(lines[l-1])) > 0 {
+			tf.MergeLine(l - 1)
+		}
+	}
+}
+
+func isIfOrBlock(s ast.Stmt) bool {
+	switch s.(type) {
+	case *ast.IfStmt, *ast.BlockStmt:
+		return true
+	}
+	return false
+}
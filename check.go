@@ -0,0 +1,167 @@
+package genx
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// OutputError is a problem in the generated code.
+type OutputError struct {
+	Pos      token.Position // in the generated file
+	Template token.Position // where the line came from in the template, invalid if it isn't from the template
+	Msg      string
+}
+
+func (e OutputError) Error() string {
+	if e.Template.IsValid() {
+		return fmt.Sprintf("%s: %s (template %s)", e.Pos, e.Msg, e.Template)
+	}
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// OutputErrors is returned when the output doesn't type-check and GenX.CheckOutput is set.
+type OutputErrors []OutputError
+
+func (e OutputErrors) Error() string {
+	lines := make([]string, 0, len(e)+1)
+	lines = append(lines, fmt.Sprintf("the output has %d problem(s):", len(e)))
+	for _, oe := range e {
+		lines = append(lines, "\t"+oe.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkOutput type-checks the output package, the problems the template already had at the same position are left
+// out, ex: a file that uses declarations of the files it was generated without or a package that can't be imported,
+// and so are the undefined names of the type arguments, which may be declared next to the output (ex: type:T=MyType).
+func (g *GenX) checkOutput(pkg ParsedPkg) error {
+	lines := make(map[string]LineMap, len(pkg))
+	for _, pf := range pkg {
+		lines[pf.Name] = pf.Lines
+	}
+	local := g.localNames()
+
+	var (
+		errs    OutputErrors
+		imports map[string]map[int]token.Position
+	)
+	add := func(pos token.Position, msg string) {
+		if name, ok := strings.CutPrefix(msg, "undefined: "); ok && local[name] {
+			return
+		}
+		oe := OutputError{Pos: pos, Template: lines[pos.Filename][pos.Line], Msg: msg}
+		if !oe.Template.IsValid() {
+			oe.Template = imports[pos.Filename][pos.Line]
+		}
+		if !g.tmplErrs[errKey(oe.Template, msg)] {
+			errs = append(errs, oe)
+		}
+	}
+
+	fset := token.NewFileSet()
+	files := make([]*ast.File, 0, len(pkg))
+	for _, pf := range pkg {
+		f, err := parser.ParseFile(fset, pf.Name, pf.Src, parser.AllErrors)
+		if el, ok := err.(scanner.ErrorList); ok {
+			for _, e := range el {
+				add(e.Pos, e.Msg)
+			}
+			continue
+		} else if err != nil {
+			add(token.Position{Filename: pf.Name}, err.Error())
+			continue
+		}
+		files = append(files, f)
+	}
+
+	// the imports aren't from the template, they point at where the template imports the same path.
+	imports = make(map[string]map[int]token.Position, len(files))
+	for _, f := range files {
+		name := fset.Position(f.Pos()).Filename
+		imports[name] = map[int]token.Position{}
+		for path, pos := range importPositions(fset, []*ast.File{f}) {
+			if tp, ok := g.tmplImports[path]; ok {
+				imports[name][pos.Line] = tp
+			}
+		}
+	}
+
+	// type errors in files that didn't parse would only be noise.
+	if len(errs) == 0 && len(files) > 0 {
+		conf := types.Config{
+			Importer: g.importer,
+			Error: func(err error) {
+				if te, ok := err.(types.Error); ok {
+					add(te.Fset.Position(te.Pos), te.Msg)
+				}
+			},
+		}
+		if conf.Importer == nil {
+			conf.Importer = sharedImporter{}
+		}
+		conf.Check(files[0].Name.Name, fset, files, nil)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		a, b := errs[i].Pos, errs[j].Pos
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		return a.Offset < b.Offset
+	})
+	return errs
+}
+
+// importPositions returns where files import each path, the first import wins.
+func importPositions(fset *token.FileSet, files []*ast.File) map[string]token.Position {
+	out := map[string]token.Position{}
+	for _, f := range files {
+		for _, is := range f.Imports {
+			if _, ok := out[is.Path.Value]; !ok {
+				out[is.Path.Value] = fset.Position(is.Pos())
+			}
+		}
+	}
+	return out
+}
+
+// localNames returns the names the type arguments use without a package, they aren't part of the template so they're
+// either builtins or declared in the output's package.
+func (g *GenX) localNames() map[string]bool {
+	out := map[string]bool{}
+	for k, v := range g.origRewriters {
+		if !strings.HasPrefix(k, "type:") {
+			continue
+		}
+		x, err := parser.ParseExpr(v)
+		if err != nil {
+			continue
+		}
+		ast.Inspect(x, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.SelectorExpr:
+				return false
+			case *ast.Ident:
+				if types.Universe.Lookup(n.Name) == nil {
+					out[n.Name] = true
+				}
+			}
+			return true
+		})
+	}
+	return out
+}
+
+// errKey is how type errors are compared between the template and the output.
+func errKey(pos token.Position, msg string) string {
+	return fmt.Sprintf("%s:%d: %s", pos.Filename, pos.Line, msg)
+}
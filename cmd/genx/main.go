@@ -1,43 +1,209 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"go/token"
+	"io/fs"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
 	"github.com/OneOfOne/cli"
 	"github.com/OneOfOne/genx"
+	"github.com/OneOfOne/genx/seeds"
 )
 
 type sflags []*[2]string
 
 func flattenFlags(in []string) (out sflags) {
 	for _, f := range in {
-		for _, p := range strings.Split(f, ",") {
-			kv := strings.Split(p, "=")
-			switch len(kv) {
-			case 2:
-				out = append(out, &[2]string{strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])})
-			case 1:
-				out = append(out, &[2]string{strings.TrimSpace(kv[0])})
-			}
+		for _, p := range splitArgs(f) {
+			k, v, _ := strings.Cut(p, "=")
+			out = append(out, &[2]string{strings.TrimSpace(k), strings.TrimSpace(v)})
 		}
 	}
 	return
 }
 
+// splitArgs splits s at the commas that aren't inside brackets or quotes,
+// ex: `F=func(a, b int) error,VT=map[string][]int` or `Sep=", ",T=int`.
+func splitArgs(s string) (out []string) {
+	var (
+		depth, start int
+		quote        rune
+		escaped      bool
+	)
+	for i, c := range s {
+		switch {
+		case quote != 0:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\' && quote != '`':
+				escaped = true
+			case c == quote:
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			out = append(out, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(out, s[start:])
+}
+
+// genFlags are the flags of the generation, shared by verify.
+var genFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "seed",
+		Usage: "use one of the `seed`s embedded in genx (" + strings.Join(seeds.Names(), ", ") + ") instead of a package.",
+	},
+	&cli.StringFlag{
+		Name:    "in",
+		Aliases: []string{"f"},
+		Usage:   "`file` to process, use `-` to process stdin.",
+	},
+
+	&cli.StringFlag{
+		Name:    "package",
+		Aliases: []string{"pkg"},
+		Usage:   "`package` to process.",
+	},
+
+	&cli.StringFlag{
+		Name:    "name",
+		Aliases: []string{"n"},
+		Usage:   "package `name` to use for output, uses the input package's name by default.",
+	},
+
+	&cli.StringSliceFlag{
+		Name:    "type",
+		Aliases: []string{"t"},
+		Usage:   "generic `type` names to remove or rename (ex: -t 'KV=string,KV=interface{}' -t RemoveThisType), repeating a type generates multiple instantiations (ex: -t T=string -t T=int), `:Name` sets the name the type gets inside identifiers (ex: -t T=*pkg.Foo:Foo).",
+	},
+
+	&cli.StringFlag{
+		Name:  "naming",
+		Usage: "naming table `file`, the names types get inside identifiers, comments and file names, one `type=Name` per line (ex: interface{}=Any).",
+	},
+
+	&cli.StringSliceFlag{
+		Name:    "selector",
+		Aliases: []string{"s"},
+		Usage:   "`selector`s to remove or rename (ex: -s 'cm.HashFn=hashers.Fnv32' -s 'x.Call=Something').",
+	},
+
+	&cli.StringSliceFlag{
+		Name:    "field",
+		Aliases: []string{"fld"},
+		Usage:   "struct `field`s to remove or rename (ex: -fld HashFn -fld privateFunc=PublicFunc).",
+	},
+
+	&cli.StringSliceFlag{
+		Name:    "func",
+		Aliases: []string{"fn"},
+		Usage:   "`func`tions and methods to remove or rename, methods can be qualified by their receiver (ex: -fn NotNeededFunc -fn Something=SomethingElse -fn TSet.Keys=SortedKeys).",
+	},
+
+	&cli.StringSliceFlag{
+		Name:  "var",
+		Usage: "package level `var`s to remove or rename, along with what depends on them (ex: -var expungedVT -var oldName=newName).",
+	},
+
+	&cli.StringSliceFlag{
+		Name:    "const",
+		Aliases: []string{"c"},
+		Usage:   "package level `const`s to remove or rename, along with what depends on them (ex: -c Unused -c oldName=newName), a value that isn't a name sets the constant like -cv (ex: -c Shards=64).",
+	},
+
+	&cli.StringSliceFlag{
+		Name:    "value",
+		Aliases: []string{"cv"},
+		Usage:   "package level constants to set the `value` of, constants that aren't in the package get added (ex: -cv Shards=64 -cv Shards=DefaultShards).",
+	},
+
+	&cli.StringSliceFlag{
+		Name:  "keep",
+		Usage: "only keep these funcs, types and methods and what they depend on (ex: -keep 'NewSet,Set.Has'), a type keeps all its methods.",
+	},
+
+	&cli.StringFlag{
+		Name:    "out",
+		Aliases: []string{"o"},
+		Value:   "/dev/stdout",
+		Usage:   "output dir if parsing a package or output filename if you want the output to be merged.",
+	},
+
+	&cli.StringSliceFlag{
+		Name:  "tags",
+		Usage: "go extra build tags, used for parsing and automatically passed to any go subcommands.",
+	},
+
+	&cli.StringSliceFlag{
+		Name:  "goFlags",
+		Usage: "extra `flags` to pass to the go command used to load packages (ex: --goFlags '-mod=vendor')",
+	},
+
+	&cli.BoolFlag{
+		Name:  "get",
+		Usage: "download the modules that aren't in the module cache, packages are only loaded from the module cache and vendor directories otherwise",
+	},
+
+	&cli.BoolFlag{
+		Name:  "tests",
+		Usage: "also rewrite the package's tests, including the external ones (package foo_test) and examples, they're written next to the output (ex: -o set.go writes set_test.go).",
+	},
+
+	&cli.BoolFlag{
+		Name:  "lineDirectives",
+		Usage: "add //line directives so compilers, panics and coverage report the positions in the template instead of the generated files",
+	},
+
+	&cli.BoolFlag{
+		Name:  "renames",
+		Usage: "list the identifiers the types rename and the ones they don't because the placeholder isn't a whole word, without writing anything",
+	},
+
+	&cli.BoolFlag{
+		Name:  "check",
+		Value: true,
+		Usage: "type-check the output and fail with the positions of its problems in the output and the template instead of writing code that doesn't compile, --check=false writes it anyway",
+	},
+
+	&cli.BoolFlag{
+		Name:  "strict",
+		Usage: "fail if any of the types, fields, funcs or selectors didn't match anything instead of just warning about it",
+	},
+
+	&cli.BoolFlag{
+		Name:    "verbose",
+		Aliases: []string{"v"},
+		Usage:   "verbose output",
+	},
+}
+
 func main() {
 	log.SetFlags(log.Lshortfile)
+	newApp().Run(os.Args)
+}
+
+// newApp returns the genx command line app.
+func newApp() *cli.App {
 	cli.VersionFlag = &cli.BoolFlag{
 		Name:    "version",
 		Aliases: []string{"V"},
 		Usage:   "print the version",
 	}
 
-	app := &cli.App{
+	return &cli.App{
 		Name:    "genx",
 		Usage:   "Generics For Go, Yet Again.",
 		Version: "v0.5",
@@ -46,100 +212,209 @@ func main() {
 			Email: "oneofone+genx <a.t> gmail <dot> com",
 		},
 		},
-		Flags: []cli.Flag{
-			&cli.StringFlag{
-				Name:  "seed",
-				Usage: "alias for -pkg github.com/OneOfOne/genx/seeds/`seed-name`",
-			},
-			&cli.StringFlag{
-				Name:    "in",
-				Aliases: []string{"f"},
-				Usage:   "`file` to process, use `-` to process stdin.",
-			},
+		Flags:  genFlags,
+		Action: runGen,
 
-			&cli.StringFlag{
-				Name:    "package",
-				Aliases: []string{"pkg"},
-				Usage:   "`package` to process.",
+		Commands: []*cli.Command{{
+			Name:  "modernize",
+			Usage: "convert a placeholder based template package (`type T interface{}`, genny's `generic.Type`) to Go generics.",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "seed",
+					Usage: "use one of the `seed`s embedded in genx (" + strings.Join(seeds.Names(), ", ") + ") instead of a package.",
+				},
+
+				&cli.StringFlag{
+					Name:    "package",
+					Aliases: []string{"pkg"},
+					Usage:   "`package` to process.",
+				},
+
+				&cli.StringFlag{
+					Name:    "name",
+					Aliases: []string{"n"},
+					Usage:   "package `name` to use for output, uses the input package's name by default.",
+				},
+
+				&cli.StringSliceFlag{
+					Name:    "type",
+					Aliases: []string{"t"},
+					Usage:   "placeholder `type` names to convert, all the empty interfaces and generic.Types by default (ex: -t KT,VT).",
+				},
+
+				&cli.StringFlag{
+					Name:    "out",
+					Aliases: []string{"o"},
+					Value:   "/dev/stdout",
+					Usage:   "output dir or output filename if you want the output to be merged.",
+				},
 			},
+			Action: runModernize,
+		}, {
+			Name:  "verify",
+			Usage: "generate the package and its tests into a temporary module and run go vet and go test there, failures point at the template.",
+			Flags: append(withoutFlags(genFlags, "out", "tests", "renames", "lineDirectives"), &cli.StringFlag{
+				Name:    "out",
+				Aliases: []string{"o"},
+				Value:   "/dev/stdout",
+				Usage:   "the output generate would use, nothing is written there but the files are merged the same way.",
+			}, &cli.BoolFlag{
+				Name:  "work",
+				Usage: "print the temporary module's directory and keep it",
+			}),
+			Action: runVerify,
+		}},
+	}
+}
 
-			&cli.StringFlag{
-				Name:    "name",
-				Aliases: []string{"n"},
-				Usage:   "package `name` to use for output, uses the input package's name by default.",
-			},
+func runGen(c *cli.Context) error {
+	gs, err := newGenXs(c)
+	if err != nil {
+		return err
+	}
+	g := gs[0]
 
-			&cli.StringSliceFlag{
-				Name:    "type",
-				Aliases: []string{"t"},
-				Usage:   "generic `type` names to remove or rename (ex: -t 'KV=string,KV=interface{}' -t RemoveThisType).",
-			},
+	inPkg, outPath, mergeFiles := inputOutput(c)
+	if err := useSeed(c, gs...); err != nil {
+		return err
+	}
 
-			&cli.StringSliceFlag{
-				Name:    "selector",
-				Aliases: []string{"s"},
-				Usage:   "`selector`s to remove or rename (ex: -s 'cm.HashFn=hashers.Fnv32' -s 'x.Call=Something').",
-			},
+	if c.Bool("lineDirectives") {
+		// the paths in the directives are relative to the directory of the output.
+		dir := outPath
+		switch {
+		case outPath == "/dev/stdout":
+			dir = "."
+		case mergeFiles:
+			dir = filepath.Dir(outPath)
+		}
+		for _, g := range gs {
+			g.LineDirectives = dir
+		}
+	}
 
-			&cli.StringSliceFlag{
-				Name:    "field",
-				Aliases: []string{"fld"},
-				Usage:   "struct `field`s to remove or rename (ex: -fld HashFn -fld privateFunc=PublicFunc).",
-			},
+	tests := c.Bool("tests")
+	if tests && (inPkg == "" || outPath == "/dev/stdout") {
+		return cli.Exit("-tests needs a -pkg or a -seed and an -o", 1)
+	}
 
-			&cli.StringSliceFlag{
-				Name:    "func",
-				Aliases: []string{"fn"},
-				Usage:   "`func`tions to remove or rename (ex: -fn NotNeededFunc -fn Something=SomethingElse).",
-			},
+	if inPkg != "" {
+		pkg, err := parsePkg(inPkg, tests, gs)
+		if err != nil {
+			return cli.Exit(fmt.Sprintf("error parsing package (%s): %v\n", inPkg, err), 1)
+		}
 
-			&cli.StringFlag{
-				Name:    "out",
-				Aliases: []string{"o"},
-				Value:   "/dev/stdout",
-				Usage:   "output dir if parsing a package or output filename if you want the output to be merged.",
-			},
+		if err = reportRewriters(c, gs); err != nil {
+			return err
+		}
 
-			&cli.StringSliceFlag{
-				Name:  "tags",
-				Usage: "go extra build tags, used for parsing and automatically passed to any go subcommands.",
-			},
+		if c.Bool("renames") {
+			printRenames(gs)
+			return nil
+		}
 
-			&cli.StringSliceFlag{
-				Name:  "goFlags",
-				Usage: "extra flags to pass to go subcommands `flags` (ex: --goFlags '-race')",
-			},
+		if mergeFiles {
+			if err = pkg.WriteAllMerged(outPath, false); err == nil && tests && hasTests(pkg) {
+				err = pkg.WriteAllMerged(strings.TrimSuffix(outPath, ".go")+"_test.go", true)
+			}
+		} else {
+			err = pkg.WritePkg(outPath)
+		}
 
-			&cli.BoolFlag{
-				Name:  "get",
-				Usage: "go get the package if it doesn't exist",
-			},
+		if err != nil {
+			return cli.Exit(err, 1)
+		}
+	} else if inFile := c.String("in"); inFile != "" {
+		if len(gs) > 1 {
+			return cli.Exit("multiple instantiations need a -pkg or a -seed", 1)
+		}
+		if inFile != "-" && inFile != "/dev/stdin" {
+			out, err := pkgFile(g, inFile)
+			if err != nil {
+				return cli.Exit(err, 2)
+			}
+			inFile = out
+		}
 
-			&cli.BoolFlag{
-				Name:    "verbose",
-				Aliases: []string{"v"},
-				Usage:   "verbose output",
-			},
-		},
-		Action: runGen,
+		pf, err := g.Parse(inFile, nil)
+		if err != nil {
+			return cli.Exit(fmt.Sprintf("error parsing file (%s): %v\n%s", inFile, err, pf.Src), 1)
+		}
+
+		if err = reportRewriters(c, gs); err != nil {
+			return err
+		}
+
+		if c.Bool("renames") {
+			printRenames(gs)
+			return nil
+		}
+
+		if err := pf.WriteFile(outPath); err != nil {
+			return cli.Exit(err, 1)
+		}
+	} else {
+		log.Println(c.FlagNames())
+		// cli.ShowAppHelpAndExit(c, 1)
 	}
 
-	// TODO: support other actions
-	app.Run(os.Args)
+	return nil
 }
 
-func runGen(c *cli.Context) error {
+// parsePkg parses the package in path once for every instantiation in gs.
+func parsePkg(path string, tests bool, gs []*genx.GenX) (genx.ParsedPkg, error) {
+	if len(gs) > 1 {
+		return genx.ParsePkgInstances(path, tests, gs...)
+	}
+	return gs[0].ParsePkg(path, tests)
+}
+
+// newGenXs returns a GenX for every instantiation the flags ask for.
+func newGenXs(c *cli.Context) ([]*genx.GenX, error) {
 	rewriters := map[string]string{}
 
-	for _, kv := range flattenFlags(c.StringSlice("type")) {
-		key, val := kv[0], kv[1]
-		if key == "" {
-			continue
+	names := map[string]string{}
+	if fn := c.String("naming"); fn != "" {
+		var err error
+		if names, err = readNamingTable(fn); err != nil {
+			return nil, cli.Exit(err, 1)
 		}
-		if val == "" {
-			val = "-"
+	}
+
+	// a -t that sets a type the current instantiation already has starts a new one, which inherits the types it
+	// doesn't override, ex: -t KT=string,VT=int -t KT=int,VT=string is two instantiations, -t KT=string -t VT=int one.
+	instances := []map[string]string{{}}
+	for _, f := range c.StringSlice("type") {
+		set := map[string]string{}
+		for _, kv := range flattenFlags([]string{f}) {
+			key, val := kv[0], kv[1]
+			if key == "" {
+				continue
+			}
+			if val == "" {
+				val = "-"
+			}
+			if typ, name := splitTypeName(val); name != "" {
+				val, names[typ] = typ, name
+			}
+			set["type:"+key] = val
+		}
+
+		cur := instances[len(instances)-1]
+		for k := range set {
+			if _, ok := cur[k]; ok {
+				next := make(map[string]string, len(cur))
+				for k, v := range cur {
+					next[k] = v
+				}
+				instances = append(instances, next)
+				cur = next
+				break
+			}
+		}
+		for k, v := range set {
+			cur[k] = v
 		}
-		rewriters["type:"+key] = val
 	}
 
 	for _, kv := range flattenFlags(c.StringSlice("selector")) {
@@ -177,122 +452,246 @@ func runGen(c *cli.Context) error {
 		rewriters["func:"+key] = val
 	}
 
-	g := genx.New(c.String("name"), rewriters)
-	g.BuildTags = append(g.BuildTags, c.StringSlice("tags")...)
+	for _, typ := range []string{"var", "const"} {
+		for _, kv := range flattenFlags(c.StringSlice(typ)) {
+			key, val := kv[0], kv[1]
+
+			if key == "" {
+				continue
+			}
+			if val == "" {
+				val = "-"
+			}
+			rewriters[typ+":"+key] = val
+		}
+	}
 
-	if c.Bool("verbose") {
-		log.Printf("rewriters: %+q", g.OrderedRewriters())
-		log.Printf("build tags: %+q", g.BuildTags)
+	for _, kv := range flattenFlags(c.StringSlice("value")) {
+		if kv[0] != "" {
+			rewriters["value:"+kv[0]] = kv[1]
+		}
 	}
 
-	var (
-		outPath = c.String("out")
+	gs := make([]*genx.GenX, 0, len(instances))
+	for _, inst := range instances {
+		for k, v := range rewriters {
+			inst[k] = v
+		}
+		g := genx.New(c.String("name"), inst)
+		g.BuildTags = append(g.BuildTags, c.StringSlice("tags")...)
+		g.GoFlags = append(g.GoFlags, c.StringSlice("goFlags")...)
+		g.Env = goEnv(c)
+		g.Naming = genx.NamingTable(names)
+		g.CheckOutput = c.Bool("check")
+		for _, kv := range flattenFlags(c.StringSlice("keep")) {
+			if kv[0] != "" {
+				g.Keep = append(g.Keep, kv[0])
+			}
+		}
 
-		mergeFiles bool
-		inPkg      string
-	)
+		if c.Bool("verbose") {
+			log.Printf("rewriters: %+q", g.OrderedRewriters())
+			log.Printf("build tags: %+q", g.BuildTags)
+		}
+		gs = append(gs, g)
+	}
+	return gs, nil
+}
 
-	switch outPath {
-	case "", "-", "/dev/stdout":
-		outPath = "/dev/stdout"
-		mergeFiles = true
+func runModernize(c *cli.Context) error {
+	var placeholders []string
+	for _, kv := range flattenFlags(c.StringSlice("type")) {
+		if kv[0] != "" {
+			placeholders = append(placeholders, kv[0])
+		}
 	}
 
-	// auto merge files if the output is a file not a dir.
-	mergeFiles = !mergeFiles && filepath.Ext(outPath) == ".go"
+	g := genx.New(c.String("name"), nil)
+	g.BuildTags = append(g.BuildTags, c.StringSlice("tags")...)
+	g.GoFlags = append(g.GoFlags, c.StringSlice("goFlags")...)
+	g.Env = goEnv(c)
 
-	if seed := c.String("seed"); seed != "" {
-		inPkg = "github.com/OneOfOne/genx/seeds/" + seed
-		mergeFiles = true
-	} else {
-		inPkg = c.String("package")
+	inPkg, outPath, mergeFiles := inputOutput(c)
+	if inPkg == "" {
+		return cli.Exit("modernize needs a -pkg or a -seed", 1)
+	}
+	if err := useSeed(c, g); err != nil {
+		return err
 	}
 
-	if inPkg != "" {
-		out, err := goListThenGet(c, g.BuildTags, inPkg)
-		if err != nil {
-			return cli.Exit(err, 2)
-		}
+	pkg, err := g.Modernize(inPkg, placeholders...)
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("error modernizing package (%s): %v\n", inPkg, err), 1)
+	}
 
-		inPkg = out
-		pkg, err := g.ParsePkg(inPkg, false)
+	if mergeFiles {
+		err = pkg.WriteAllMerged(outPath, false)
+	} else {
+		err = pkg.WritePkg(outPath)
+	}
 
-		if err != nil {
-			return cli.Exit(fmt.Sprintf("error parsing package (%s): %v\n", inPkg, err), 1)
-		}
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	return nil
+}
 
-		if mergeFiles {
-			err = pkg.WriteAllMerged(outPath, false)
-		} else {
-			err = pkg.WritePkg(outPath)
-		}
+var rewriterFlags = map[string]string{
+	"type":     "-t",
+	"field":    "-fld",
+	"func":     "-fn",
+	"selector": "-s",
+	"var":      "-var",
+	"const":    "-const",
+	"value":    "-value",
+}
 
-		if err != nil {
-			return cli.Exit(err, 1)
-		}
-	} else if inFile := c.String("in"); inFile != "" {
-		if inFile != "-" && inFile != "/dev/stdin" {
-			out, err := goListThenGet(c, g.BuildTags, inFile)
-			if err != nil {
-				return cli.Exit(err, 2)
+// reportRewriters lists what was removed in verbose mode and warns about the rewriters that didn't match anything,
+// or fails if -strict is set.
+func reportRewriters(c *cli.Context, gs []*genx.GenX) error {
+	var unused []string
+	seen := map[string]bool{}
+	for _, g := range gs {
+		if c.Bool("verbose") {
+			for _, r := range g.Removed() {
+				log.Printf("removed: %s", r)
 			}
-			inFile = out
 		}
-
-		pf, err := g.Parse(inFile, nil)
-		if err != nil {
-			return cli.Exit(fmt.Sprintf("error parsing file (%s): %v\n%s", inFile, err, pf.Src), 1)
+		for _, k := range g.Unused() {
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			idx := strings.Index(k, ":")
+			unused = append(unused, rewriterFlags[k[:idx]]+" "+k[idx+1:])
 		}
+	}
 
-		if err := pf.WriteFile(outPath); err != nil {
-			return cli.Exit(err, 1)
-		}
-	} else {
-		log.Println(c.FlagNames())
-		// cli.ShowAppHelpAndExit(c, 1)
+	if len(unused) == 0 {
+		return nil
 	}
 
+	msg := fmt.Sprintf("didn't match anything: %s", strings.Join(unused, ", "))
+	if c.Bool("strict") {
+		return cli.Exit(msg, 1)
+	}
+	log.Printf("warning: %s", msg)
 	return nil
 }
-func execCmd(ctx *cli.Context, c string, args ...string) (string, error) {
-	cmd := exec.Command(c, args...)
-	if ctx.Bool("verbose") {
-		log.Printf("executing: %s %s", c, strings.Join(args, " "))
+
+// splitTypeName splits the name the type should get inside identifiers from a type argument, ex: *pkg.Foo:Foo.
+func splitTypeName(v string) (typ, name string) {
+	if idx := strings.LastIndex(v, ":"); idx > 0 && token.IsIdentifier(v[idx+1:]) {
+		return v[:idx], v[idx+1:]
 	}
-	out, err := cmd.CombinedOutput()
-	return strings.TrimSpace(string(out)), err
+	return v, ""
 }
 
-func goListThenGet(ctx *cli.Context, tags []string, path string) (out string, err error) {
-	if _, err = os.Stat(path); err == nil {
-		return path, nil
+// readNamingTable reads a naming table, one `type=Name` per line, empty lines and lines starting with # are ignored.
+func readNamingTable(fn string) (map[string]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
 	}
+	defer f.Close()
 
-	isFile := filepath.Ext(path) == ".go"
-	dir := path
-	if isFile {
-		dir = filepath.Dir(path)
+	names := map[string]string{}
+	sc := bufio.NewScanner(f)
+	for ln := 1; sc.Scan(); ln++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		idx := strings.LastIndex(line, "=")
+		if idx == -1 {
+			return nil, fmt.Errorf("%s:%d: expected type=Name, got %q", fn, ln, line)
+		}
+		typ, name := strings.TrimSpace(line[:idx]), strings.TrimSpace(line[idx+1:])
+		if typ == "" || !token.IsIdentifier(name) {
+			return nil, fmt.Errorf("%s:%d: expected type=Name, got %q", fn, ln, line)
+		}
+		names[typ] = name
 	}
+	return names, sc.Err()
+}
 
-	args := []string{"-tags", strings.Join(tags, " ")}
-	args = append(args, ctx.StringSlice("goFlags")...)
-
-	args = append(args, dir)
-
-	listArgs := append([]string{"list", "-f", "{{.Dir}}"}, args...)
-
-	if out, err = execCmd(ctx, "go", listArgs...); err != nil && strings.Contains(out, "cannot find package") {
-		if !ctx.Bool("get") {
-			out = fmt.Sprintf("`%s` not found and `--get` isn't specified.", path)
-			return
+// printRenames prints the identifiers renamed by each instantiation.
+func printRenames(gs []*genx.GenX) {
+	for i, g := range gs {
+		if len(gs) > 1 {
+			fmt.Printf("# %s\n", strings.Join(g.OrderedRewriters(), ", "))
+		}
+		for _, r := range g.Renames() {
+			fmt.Println(r)
 		}
-		if out, err = execCmd(ctx, "go", append([]string{"get", "-u", "-v"}, args...)...); err == nil && isFile {
-			out, err = execCmd(ctx, "go", listArgs...)
+		if i < len(gs)-1 {
+			fmt.Println()
 		}
 	}
+}
 
-	if err == nil && isFile {
-		out = filepath.Join(out, filepath.Base(path))
+// hasTests reports whether pkg has any test files.
+func hasTests(pkg genx.ParsedPkg) bool {
+	for _, pf := range pkg {
+		if strings.HasSuffix(pf.Name, "_test.go") {
+			return true
+		}
+	}
+	return false
+}
+
+// inputOutput returns the input package and where and how to write the output.
+func inputOutput(c *cli.Context) (inPkg, outPath string, mergeFiles bool) {
+	switch outPath = c.String("out"); outPath {
+	case "", "-", "/dev/stdout":
+		outPath = "/dev/stdout"
+		mergeFiles = true
+	}
+
+	// auto merge files if the output is a file not a dir.
+	mergeFiles = !mergeFiles && filepath.Ext(outPath) == ".go"
+
+	if seed := c.String("seed"); seed != "" {
+		inPkg = seed
+		mergeFiles = true
+	} else {
+		inPkg = c.String("package")
 	}
 	return
 }
+
+// useSeed makes gs read the package from the seeds embedded in genx if -seed is set.
+func useSeed(c *cli.Context, gs ...*genx.GenX) error {
+	seed := c.String("seed")
+	if seed == "" {
+		return nil
+	}
+	if fi, err := fs.Stat(seeds.FS, seed); err != nil || !fi.IsDir() {
+		return cli.Exit(fmt.Sprintf("unknown seed %s, available seeds: %s", seed, strings.Join(seeds.Names(), ", ")), 1)
+	}
+	for _, g := range gs {
+		g.FS = seeds.FS
+	}
+	return nil
+}
+
+// goEnv returns what to add to the environment of the go command,
+// which is kept from downloading modules unless --get is set.
+func goEnv(c *cli.Context) []string {
+	if !c.Bool("get") {
+		return []string{"GOPROXY=off"}
+	}
+	return nil
+}
+
+// pkgFile returns the path of file, which can be relative to a package (ex: github.com/OneOfOne/cmap/lmap.go).
+func pkgFile(g *genx.GenX, file string) (string, error) {
+	if _, err := os.Stat(file); err == nil {
+		return file, nil
+	}
+	dir, err := g.PkgDir(filepath.Dir(file))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(file)), nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	testCases := []struct {
+		In  string
+		Out []string
+	}{
+		{"KT=string,VT=int", []string{"KT=string", "VT=int"}},
+		{"F=func(a, b int) error,VT=map[string][]int", []string{"F=func(a, b int) error", "VT=map[string][]int"}},
+		{`Sep=", ",T=int`, []string{`Sep=", "`, "T=int"}},
+		{`Sep="\", ",T=int`, []string{`Sep="\", "`, "T=int"}},
+		{"Sep=`a\\`,T=int", []string{"Sep=`a\\`", "T=int"}},
+		{`Comma=',',T=int`, []string{`Comma=','`, "T=int"}},
+	}
+	for _, tc := range testCases {
+		if out := splitArgs(tc.In); !reflect.DeepEqual(out, tc.Out) {
+			t.Errorf("splitArgs(%q) = %q, expected %q", tc.In, out, tc.Out)
+		}
+	}
+}
+
+func TestTypeInstances(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "maps.go")
+	fatalIf(t, runApp("-seed", "atomicMap", "-t", "KT=string,VT=int", "-t", "KT=int,VT=string", "-n", "maps", "-o", out))
+	src, err := os.ReadFile(out)
+	fatalIf(t, err)
+
+	var types []string
+	for _, m := range regexp.MustCompile(`(?m)^type (Map\w+) struct`).FindAllSubmatch(src, -1) {
+		types = append(types, string(m[1]))
+	}
+	if exp := []string{"MapStringInt", "MapIntString"}; !reflect.DeepEqual(types, exp) {
+		t.Errorf("expected %q, got %q:\n%s", exp, types, src)
+	}
+
+	// types set by separate flags are still a single instantiation.
+	fatalIf(t, runApp("-seed", "atomicMap", "-t", "KT=string", "-t", "VT=int", "-n", "maps", "-o", out))
+	src, err = os.ReadFile(out)
+	fatalIf(t, err)
+	if n := len(regexp.MustCompile(`(?m)^type Map\w+ struct`).FindAll(src, -1)); n != 1 {
+		t.Errorf("expected a single instantiation, got %d:\n%s", n, src)
+	}
+}
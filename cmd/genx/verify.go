@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/version"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/OneOfOne/cli"
+	"github.com/OneOfOne/genx"
+)
+
+// runVerify generates the package along with its tests into a temporary module, then runs go vet and go test there.
+func runVerify(c *cli.Context) error {
+	gs, err := newGenXs(c)
+	if err != nil {
+		return err
+	}
+
+	inPkg, outPath, mergeFiles := inputOutput(c)
+	if err := useSeed(c, gs...); err != nil {
+		return err
+	}
+
+	var pkg genx.ParsedPkg
+	switch inFile := c.String("in"); {
+	case inPkg != "":
+		if pkg, err = parsePkg(inPkg, true, gs); err != nil {
+			return cli.Exit(fmt.Sprintf("error parsing package (%s): %v\n", inPkg, err), 1)
+		}
+	case inFile != "" && inFile != "-" && len(gs) == 1:
+		if inFile, err = pkgFile(gs[0], inFile); err != nil {
+			return cli.Exit(err, 2)
+		}
+		pf, err := gs[0].Parse(inFile, nil)
+		if err != nil {
+			return cli.Exit(fmt.Sprintf("error parsing file (%s): %v\n%s", inFile, err, pf.Src), 1)
+		}
+		pf.Name = filepath.Base(pf.Name)
+		pkg = genx.ParsedPkg{pf}
+	default:
+		return cli.Exit("verify needs a -pkg, a -seed or an -in file", 1)
+	}
+
+	if err = reportRewriters(c, gs); err != nil {
+		return err
+	}
+
+	// verify what generate would write.
+	if inPkg != "" && mergeFiles {
+		if pkg, err = mergePkg(pkg, outPath); err != nil {
+			return cli.Exit(err, 1)
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "genx-verify-")
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	if c.Bool("work") {
+		log.Printf("work dir: %s", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+
+	for _, pf := range pkg {
+		if err = os.WriteFile(filepath.Join(dir, pf.Name), pf.Src, 0o644); err != nil {
+			return cli.Exit(err, 1)
+		}
+	}
+	env := append(os.Environ(), goEnv(c)...)
+	if err = initModule(dir, env); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	tags := "-tags=" + strings.Join(c.StringSlice("tags"), ",")
+	var failed []string
+	for _, args := range [][]string{{"vet", tags, "."}, {"test", "-vet=off", tags, "."}} {
+		cmd := exec.Command("go", args...)
+		cmd.Dir, cmd.Env = dir, env
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			failed = append(failed, "go "+args[0])
+			os.Stderr.Write(templatePositions(out, dir, pkg))
+		} else if c.Bool("verbose") {
+			os.Stderr.Write(out)
+		}
+	}
+
+	if len(failed) > 0 {
+		return cli.Exit(strings.Join(failed, ", ")+" failed", 1)
+	}
+	return nil
+}
+
+// mergePkg merges pkg and its tests the way generate does when it writes to outPath.
+func mergePkg(pkg genx.ParsedPkg, outPath string) (genx.ParsedPkg, error) {
+	name := filepath.Base(outPath)
+	if outPath == "/dev/stdout" {
+		name = "genx_gen.go"
+	}
+
+	pf, err := pkg.MergeAllAs(name, false)
+	if err != nil {
+		return nil, err
+	}
+	merged := genx.ParsedPkg{pf}
+	if hasTests(pkg) {
+		if pf, err = pkg.MergeAllAs(strings.TrimSuffix(name, ".go")+"_test.go", true); err != nil {
+			return nil, err
+		}
+		merged = append(merged, pf)
+	}
+	return merged, nil
+}
+
+// initModule makes dir a module, which requires the modules of the current directory, if any, so the generated code
+// can use their packages and their dependencies, env is the environment of the go command.
+func initModule(dir string, env []string) error {
+	goCmd := func(dir string, args ...string) *exec.Cmd {
+		cmd := exec.Command("go", args...)
+		cmd.Dir, cmd.Env = dir, env
+		return cmd
+	}
+
+	// in a workspace, go list -m prints every module of the go.work.
+	var (
+		goVersion     string
+		require, repl []string
+		sum           []byte
+	)
+	if out, err := goCmd("", "list", "-m", "-json").Output(); err == nil {
+		dec := json.NewDecoder(bytes.NewReader(out))
+		for {
+			var mod struct{ Path, Dir, GoVersion string }
+			if err := dec.Decode(&mod); err != nil {
+				if err != io.EOF {
+					return fmt.Errorf("go list -m: %v", err)
+				}
+				break
+			}
+			if mod.Dir == "" {
+				continue
+			}
+			if version.Compare("go"+mod.GoVersion, "go"+goVersion) > 0 {
+				goVersion = mod.GoVersion
+			}
+			require = append(require, fmt.Sprintf("\t%s v0.0.0\n", mod.Path))
+			repl = append(repl, fmt.Sprintf("\t%s => %s\n", mod.Path, mod.Dir))
+			if b, err := os.ReadFile(filepath.Join(mod.Dir, "go.sum")); err == nil {
+				sum = append(sum, b...)
+			}
+		}
+	}
+	if goVersion == "" {
+		out, err := goCmd("", "env", "GOVERSION").Output()
+		if err != nil {
+			return err
+		}
+		goVersion = strings.TrimPrefix(strings.TrimSpace(string(out)), "go")
+	}
+
+	gomod := "module genx.verify\n\ngo " + goVersion + "\n"
+	if len(require) > 0 {
+		gomod += "\nrequire (\n" + strings.Join(require, "") + ")\n\nreplace (\n" + strings.Join(repl, "") + ")\n"
+	}
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(gomod), 0o644); err != nil {
+		return err
+	}
+	if sum != nil {
+		if err := os.WriteFile(filepath.Join(dir, "go.sum"), sum, 0o644); err != nil {
+			return err
+		}
+	}
+
+	if out, err := goCmd(dir, "mod", "tidy", "-e").CombinedOutput(); err != nil {
+		return fmt.Errorf("go mod tidy: %v\n%s", err, out)
+	}
+	return nil
+}
+
+// goPosRE matches the positions the go command and tests print, ex: ./set_string.go:12:3 or /tmp/x/set_string.go:12.
+var goPosRE = regexp.MustCompile(`((?:[^\s:]*/)?([^\s/:]+\.go)):(\d+)(?::(\d+))?`)
+
+// templatePositions replaces the positions in the generated files of pkg in out with the template positions they
+// came from, followed by the generated one, ex: seeds/set/set.go:12 (set_string.go:9:3).
+func templatePositions(out []byte, dir string, pkg genx.ParsedPkg) []byte {
+	files := make(map[string]genx.ParsedFile, len(pkg))
+	for _, pf := range pkg {
+		files[pf.Name] = pf
+	}
+	wd, _ := os.Getwd()
+
+	return goPosRE.ReplaceAllFunc(out, func(m []byte) []byte {
+		p := goPosRE.FindSubmatch(m)
+		fpath, name := string(p[1]), string(p[2])
+		if fpath != name && !strings.HasPrefix(fpath, "./") && filepath.Dir(fpath) != dir {
+			return m
+		}
+		line, _ := strconv.Atoi(string(p[3]))
+		pos, ok := files[name].Lines[line]
+		if !ok {
+			return m
+		}
+		fn := pos.Filename
+		if rel, err := filepath.Rel(wd, fn); err == nil && !strings.HasPrefix(rel, "..") {
+			fn = rel
+		}
+		return []byte(fmt.Sprintf("%s:%d (%s)", fn, pos.Line, bytes.TrimPrefix(m, []byte(dir+"/"))))
+	})
+}
+
+// withoutFlags returns flags without the ones named names.
+func withoutFlags(flags []cli.Flag, names ...string) (out []cli.Flag) {
+	skip := map[string]bool{}
+	for _, n := range names {
+		skip[n] = true
+	}
+	for _, f := range flags {
+		if !skip[f.Names()[0]] {
+			out = append(out, f)
+		}
+	}
+	return
+}
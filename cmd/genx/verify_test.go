@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/OneOfOne/cli"
+	"github.com/OneOfOne/genx"
+)
+
+func TestTemplatePositions(t *testing.T) {
+	wd, err := os.Getwd()
+	fatalIf(t, err)
+	pkg := genx.ParsedPkg{{
+		Name:  "set_string.go",
+		Lines: genx.LineMap{9: {Filename: filepath.Join(wd, "tmpl", "set.go"), Line: 12}},
+	}}
+
+	out := "./set_string.go:9:3: x declared and not used\n" +
+		"/tmp/verify/set_string.go:9: bad\n" +
+		"set_string.go:10: unmapped\n" +
+		"/elsewhere/set_string.go:9: other dir\n"
+	exp := "tmpl/set.go:12 (./set_string.go:9:3): x declared and not used\n" +
+		"tmpl/set.go:12 (set_string.go:9): bad\n" +
+		"set_string.go:10: unmapped\n" +
+		"/elsewhere/set_string.go:9: other dir\n"
+	if got := string(templatePositions([]byte(out), "/tmp/verify", pkg)); got != exp {
+		t.Errorf("expected:\n%s\ngot:\n%s", exp, got)
+	}
+}
+
+func TestInitModule(t *testing.T) {
+	ws := t.TempDir()
+	for name, src := range map[string]string{
+		"go.work":  "go 1.22\n\nuse (\n\t./a\n\t./b\n)\n",
+		"a/go.mod": "module example.com/a\n\ngo 1.21\n",
+		"a/a.go":   "package a\n\nconst A = 1\n",
+		"b/go.mod": "module example.com/b\n\ngo 1.22\n",
+		"b/b.go":   "package b\n\nconst B = 2\n",
+	} {
+		fatalIf(t, os.MkdirAll(filepath.Join(ws, filepath.Dir(name)), 0o755))
+		fatalIf(t, os.WriteFile(filepath.Join(ws, name), []byte(src), 0o644))
+	}
+	chdir(t, filepath.Join(ws, "a"))
+	t.Setenv("GOFLAGS", "") // workspaces don't allow -mod=mod.
+
+	dir := t.TempDir()
+	src := "package x\n\nimport (\n\t\"example.com/a\"\n\t\"example.com/b\"\n)\n\nconst X = a.A + b.B\n"
+	fatalIf(t, os.WriteFile(filepath.Join(dir, "x.go"), []byte(src), 0o644))
+	fatalIf(t, initModule(dir, append(os.Environ(), "GOPROXY=off")))
+
+	gomod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	fatalIf(t, err)
+	for _, re := range []string{`(?m)^go 1\.22$`, `example\.com/a v0\.0\.0`, `example\.com/b v0\.0\.0`, `example\.com/a => .*a\n`, `example\.com/b => .*b\n`} {
+		if !regexp.MustCompile(re).Match(gomod) {
+			t.Errorf("%s didn't match:\n%s", re, gomod)
+		}
+	}
+}
+
+func TestRunVerify(t *testing.T) {
+	if err := runApp("verify", "-seed", "set", "-t", "T=int"); err != nil {
+		t.Fatal(err)
+	}
+
+	// failures point at the template, in the files generate would write.
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"go.mod":       "module example.com/tmpl\n\ngo 1.21\n",
+		"tmpl.go":      "package tmpl\n\ntype T interface{}\n\nfunc Zero() (z T) { return }\n",
+		"tmpl_test.go": "package tmpl\n\nimport \"testing\"\n\nfunc TestZero(t *testing.T) {\n\tt.Errorf(\"zero: %v\", Zero())\n}\n",
+	} {
+		fatalIf(t, os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644))
+	}
+
+	stderr := os.Stderr
+	f, err := os.CreateTemp(t.TempDir(), "stderr")
+	fatalIf(t, err)
+	os.Stderr = f
+	err = runApp("verify", "-pkg", dir, "-t", "T=int", "-o", "tmpl.go")
+	os.Stderr = stderr
+	if err == nil || err.Error() != "go test failed" {
+		t.Fatalf("expected go test to fail, got %v", err)
+	}
+
+	out, err := os.ReadFile(f.Name())
+	fatalIf(t, err)
+	if exp := filepath.Join(dir, "tmpl_test.go") + ":6 (tmpl_test.go:"; !strings.Contains(string(out), exp) {
+		t.Errorf("expected %s in:\n%s", exp, out)
+	}
+}
+
+// runApp runs the genx app with args, returning its error instead of exiting.
+func runApp(args ...string) error {
+	app := newApp()
+	app.ExitErrHandler = func(*cli.Context, error) {}
+	return app.Run(append([]string{"genx"}, args...))
+}
+
+// chdir changes the working directory to dir until t is done.
+func chdir(t *testing.T, dir string) {
+	wd, err := os.Getwd()
+	fatalIf(t, err)
+	fatalIf(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func fatalIf(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,133 @@
+package genx
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// symbol is a declaration as it was named in the template.
+type symbol struct {
+	name string
+	pos  token.Position
+}
+
+// symbolTable holds the declarations of the generated package by scope then name,
+// the scope of methods and fields is the name of their type, everything else is in "".
+type symbolTable map[string]map[string]symbol
+
+// declIdents calls fn for every package level declaration, method, field and interface method declared in f.
+func declIdents(f *ast.File, fn func(scope string, id *ast.Ident)) {
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			var scope string
+			if d.Recv != nil && len(d.Recv.List) == 1 {
+				x, _ := splitIndexExpr(d.Recv.List[0].Type)
+				if id := getIdent(x); id != nil {
+					scope = id.Name
+				}
+			}
+			// a package can have as many init funcs as it wants.
+			if scope == "" && d.Name.Name == "init" {
+				break
+			}
+			fn(scope, d.Name)
+
+		case *ast.GenDecl:
+			for _, s := range d.Specs {
+				switch s := s.(type) {
+				case *ast.TypeSpec:
+					fn("", s.Name)
+					switch t := s.Type.(type) {
+					case *ast.StructType:
+						fieldIdents(t.Fields, true, func(id *ast.Ident) { fn(s.Name.Name, id) })
+					case *ast.InterfaceType:
+						fieldIdents(t.Methods, false, func(id *ast.Ident) { fn(s.Name.Name, id) })
+					}
+				case *ast.ValueSpec:
+					for _, n := range s.Names {
+						fn("", n)
+					}
+				}
+			}
+		}
+	}
+}
+
+// fieldIdents calls fn with the names of the fields in fl, embedded fields are named after their type
+// unless embedded is false (interfaces only get the methods of what they embed).
+func fieldIdents(fl *ast.FieldList, embedded bool, fn func(id *ast.Ident)) {
+	if fl == nil {
+		return
+	}
+	for _, f := range fl.List {
+		if len(f.Names) > 0 {
+			for _, n := range f.Names {
+				fn(n)
+			}
+			continue
+		}
+		if !embedded {
+			continue
+		}
+		if id := embeddedIdent(f.Type); id != nil {
+			fn(id)
+		}
+	}
+}
+
+// templateSymbols returns the original name and position of every declaration in f, it has to be called before rewriting.
+func templateSymbols(fset *token.FileSet, f *ast.File) map[*ast.Ident]symbol {
+	out := map[*ast.Ident]symbol{}
+	declIdents(f, func(scope string, id *ast.Ident) {
+		name := id.Name
+		if scope != "" {
+			name = scope + "." + name
+		}
+		out[id] = symbol{name: name, pos: fset.Position(id.Pos())}
+	})
+	return out
+}
+
+// checkCollisions adds the declarations of the rewritten file f to g.symbols and fails if any of them
+// ended up with the same name as a different declaration of the template.
+func (g *GenX) checkCollisions(f *ast.File, orig map[*ast.Ident]symbol) {
+	var collisions []string
+	declIdents(f, func(scope string, id *ast.Ident) {
+		sym, ok := orig[id]
+		if !ok || id.Name == "_" {
+			return
+		}
+
+		syms := g.symbols[scope]
+		if syms == nil {
+			syms = map[string]symbol{}
+			g.symbols[scope] = syms
+		}
+
+		prev, ok := syms[id.Name]
+		if !ok {
+			syms[id.Name] = sym
+			return
+		}
+
+		// the template itself declaring something twice isn't our problem.
+		if prev.name == sym.name {
+			return
+		}
+
+		name := id.Name
+		if scope != "" {
+			name = scope + "." + name
+		}
+		collisions = append(collisions, fmt.Sprintf("%s (%s) and %s (%s) are both renamed to %s", prev.name, prev.pos, sym.name, sym.pos, name))
+	})
+
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		g.fail(fmt.Errorf("colliding identifiers:\n\t%s", strings.Join(collisions, "\n\t")))
+	}
+}
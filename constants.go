@@ -0,0 +1,363 @@
+package genx
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/OneOfOne/xast"
+)
+
+// isConstValue reports whether the rewriter sets the value of a constant (ex: value:Shards=64)
+// rather than renaming or removing something.
+func isConstValue(k string) bool {
+	return strings.HasPrefix(k, "value:")
+}
+
+// constValues returns rewriters with the const: keys that set a value instead of a name (ex: const:Shards=64) turned
+// into value: keys, renaming a constant to 64 would never compile.
+func constValues(rewriters map[string]string) map[string]string {
+	var out map[string]string
+	for k, v := range rewriters {
+		name, ok := strings.CutPrefix(k, "const:")
+		if !ok || v == "-" || token.IsIdentifier(v) {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string, len(rewriters))
+			for k, v := range rewriters {
+				out[k] = v
+			}
+		}
+		delete(out, k)
+		out["value:"+name] = v
+	}
+	if out == nil {
+		return rewriters
+	}
+	return out
+}
+
+// prepareConsts checks that the values of the value: rewriters fit the constants they replace and computes the new
+// value of every package level constant that depends on them, the ones missing from the template get injected.
+func (g *GenX) prepareConsts(fset *token.FileSet, files []*ast.File) {
+	g.constVals, g.constExprs, g.injectConsts = map[types.Object]constant.Value{}, map[types.Object]ast.Expr{}, nil
+	if len(g.constSrc) == 0 || g.pkg == nil {
+		return
+	}
+
+	for name, src := range g.constSrc {
+		key := "value:" + name
+		x, err := parser.ParseExpr(src)
+		if err != nil {
+			g.fail(fmt.Errorf("%s=%s: %v", key, src, err))
+			continue
+		}
+
+		tv, err := types.Eval(fset, g.pkg, token.NoPos, src)
+		if err == nil && tv.Value == nil {
+			err = fmt.Errorf("%s isn't a constant", src)
+		}
+		if err != nil {
+			g.fail(fmt.Errorf("%s=%s: %v", key, src, err))
+			continue
+		}
+
+		g.use(key)
+		obj := g.pkg.Scope().Lookup(name)
+		if obj == nil {
+			g.injectConsts = append(g.injectConsts, fmt.Sprintf("const %s = %s", name, src))
+			continue
+		}
+
+		c, ok := obj.(*types.Const)
+		if !ok {
+			g.fail(fmt.Errorf("%s=%s: %s isn't a constant (%s)", key, src, name, fset.Position(obj.Pos())))
+			continue
+		}
+
+		v, err := g.fitConst(fset, c.Type(), tv)
+		if err != nil {
+			g.fail(fmt.Errorf("%s=%s: %v (%s)", key, src, err, fset.Position(obj.Pos())))
+			continue
+		}
+		g.constVals[c], g.constExprs[c] = v, x
+	}
+	sort.Strings(g.injectConsts)
+
+	// constants that depend on the ones that changed, in declaration order, since they can depend on each other.
+	for _, f := range files {
+		for _, d := range f.Decls {
+			gd, ok := d.(*ast.GenDecl)
+			if !ok || gd.Tok != token.CONST {
+				continue
+			}
+			for _, s := range gd.Specs {
+				vs := s.(*ast.ValueSpec)
+				for i, n := range vs.Names {
+					c, ok := g.info.Defs[n].(*types.Const)
+					if !ok || g.constVals[c] != nil || i >= len(vs.Values) || !g.dependsOnConsts(vs.Values[i]) {
+						continue
+					}
+					v, ok := g.evalConst(vs.Values[i])
+					if !ok {
+						continue
+					}
+					if v, err := g.fitConst(fset, c.Type(), types.TypeAndValue{Type: types.Typ[untypedKind(v)], Value: v}); err != nil {
+						g.fail(fmt.Errorf("%s = %s: %v (%s)", n.Name, types.ExprString(vs.Values[i]), err, fset.Position(n.Pos())))
+					} else {
+						g.constVals[c] = v
+					}
+				}
+			}
+		}
+	}
+}
+
+// fitConst checks that the constant tv can be used as a value of type t and returns it converted to t.
+func (g *GenX) fitConst(fset *token.FileSet, t types.Type, tv types.TypeAndValue) (constant.Value, error) {
+	target := types.Default(t)
+	if !types.AssignableTo(tv.Type, target) {
+		return nil, fmt.Errorf("%s can't be used as %s", tv.Type, target)
+	}
+
+	lit, ok := constLit(tv.Value)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't supported", tv.Value.Kind())
+	}
+
+	conv := types.TypeString(target, types.RelativeTo(g.pkg)) + "(" + types.ExprString(lit) + ")"
+	ctv, err := types.Eval(fset, g.pkg, token.NoPos, conv)
+	if err != nil {
+		return nil, err
+	}
+	// untyped constants keep their exact value rather than the one of their default type.
+	if b, ok := t.(*types.Basic); ok && b.Info()&types.IsUntyped != 0 {
+		return tv.Value, nil
+	}
+	return ctv.Value, nil
+}
+
+// dependsOnConsts reports whether x refers to any of the constants that changed.
+func (g *GenX) dependsOnConsts(x ast.Node) (found bool) {
+	if len(g.constVals) == 0 {
+		return false
+	}
+	ast.Inspect(x, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && !found {
+			found = g.constVals[g.info.Uses[id]] != nil
+		}
+		return !found
+	})
+	return
+}
+
+// evalConst evaluates the constant expression x with the new values of the constants that changed.
+func (g *GenX) evalConst(x ast.Expr) (v constant.Value, ok bool) {
+	switch x := x.(type) {
+	case *ast.BasicLit:
+		v = constant.MakeFromLiteral(x.Value, x.Kind, 0)
+	case *ast.Ident:
+		if v = g.constVals[g.info.Uses[x]]; v == nil {
+			v = g.info.Types[x].Value
+		}
+	case *ast.ParenExpr:
+		return g.evalConst(x.X)
+	case *ast.UnaryExpr:
+		if x.Op == token.XOR {
+			// depends on the size of the type.
+			return nil, false
+		}
+		if v, ok = g.evalConst(x.X); ok {
+			v = constant.UnaryOp(x.Op, v, 0)
+		}
+	case *ast.BinaryExpr:
+		var l, r constant.Value
+		if l, ok = g.evalConst(x.X); !ok {
+			return
+		}
+		if r, ok = g.evalConst(x.Y); !ok {
+			return
+		}
+		switch op := x.Op; op {
+		case token.SHL, token.SHR:
+			s, exact := constant.Uint64Val(constant.ToInt(r))
+			if !exact {
+				return nil, false
+			}
+			v = constant.Shift(l, op, uint(s))
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			v = constant.MakeBool(constant.Compare(l, op, r))
+		case token.QUO:
+			if l.Kind() == constant.Int && r.Kind() == constant.Int {
+				if constant.Sign(r) == 0 {
+					return nil, false
+				}
+				op = token.QUO_ASSIGN // integer division
+			}
+			v = constant.BinaryOp(l, op, r)
+		default:
+			v = constant.BinaryOp(l, op, r)
+		}
+	}
+	return v, v != nil && v.Kind() != constant.Unknown
+}
+
+// constTypeOf returns the type of the constant expression x, or nil if it's untyped.
+// The type checker records the type untyped expressions are converted to, so it has to be figured out from the constants it uses.
+func (g *GenX) constTypeOf(x ast.Expr) (t types.Type) {
+	ast.Inspect(x, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && t == nil {
+			if c, ok := g.info.Uses[id].(*types.Const); ok {
+				if b, ok := c.Type().(*types.Basic); !ok || b.Info()&types.IsUntyped == 0 {
+					t = c.Type()
+				}
+			}
+		}
+		return t == nil
+	})
+	return
+}
+
+// constLit returns the literal of v.
+func constLit(v constant.Value) (ast.Expr, bool) {
+	switch v.Kind() {
+	case constant.Bool:
+		return ast.NewIdent(v.ExactString()), true
+	case constant.String:
+		return &ast.BasicLit{Kind: token.STRING, Value: v.ExactString()}, true
+	case constant.Int:
+		return &ast.BasicLit{Kind: token.INT, Value: v.ExactString()}, true
+	case constant.Float:
+		return floatLit(v), true
+	}
+	return nil, false
+}
+
+// floatLit returns the exact literal of the float constant v, or a division for the fractions that don't have one (ex: 1.0 / 3).
+func floatLit(v constant.Value) ast.Expr {
+	lit := func(s string) *ast.BasicLit {
+		if !strings.ContainsAny(s, ".eE") {
+			s += ".0"
+		}
+		return &ast.BasicLit{Kind: token.FLOAT, Value: s}
+	}
+	switch x := constant.Val(v).(type) {
+	case *big.Rat:
+		if x.IsInt() {
+			return lit(x.Num().String())
+		}
+		if digits, ok := decimalDigits(x.Denom()); ok {
+			return lit(strings.TrimRight(x.FloatString(digits), "0"))
+		}
+		return &ast.BinaryExpr{X: lit(x.Num().String()), Op: token.QUO, Y: &ast.BasicLit{Kind: token.INT, Value: x.Denom().String()}}
+	case *big.Float:
+		return lit(x.Text('g', -1))
+	}
+	return lit(v.String())
+}
+
+// decimalDigits returns the number of decimal digits 1/d has, if it doesn't repeat.
+func decimalDigits(d *big.Int) (int, bool) {
+	d = new(big.Int).Set(d)
+	var digits [2]int
+	for i, f := range []int64{2, 5} {
+		for q, m, f := new(big.Int), new(big.Int), big.NewInt(f); ; digits[i]++ {
+			if q.QuoRem(d, f, m); m.Sign() != 0 {
+				break
+			}
+			d.Set(q)
+		}
+	}
+	if digits[0] < digits[1] {
+		digits[0] = digits[1]
+	}
+	return digits[0], d.IsInt64() && d.Int64() == 1
+}
+
+func untypedKind(v constant.Value) types.BasicKind {
+	switch v.Kind() {
+	case constant.Bool:
+		return types.UntypedBool
+	case constant.String:
+		return types.UntypedString
+	case constant.Int:
+		return types.UntypedInt
+	case constant.Float:
+		return types.UntypedFloat
+	case constant.Complex:
+		return types.UntypedComplex
+	}
+	return types.Invalid
+}
+
+// rewriteValueSpec sets the values of the constants replaced by value: rewriters.
+func (g *GenX) rewriteValueSpec(node *xast.Node) *xast.Node {
+	n := node.Node().(*ast.ValueSpec)
+	if len(g.constExprs) == 0 {
+		return node
+	}
+
+	for i, id := range n.Names {
+		x := g.constExprs[g.info.Defs[id]]
+		if x == nil {
+			continue
+		}
+		if len(n.Values) != len(n.Names) {
+			g.fail(fmt.Errorf("value:%s: can't replace an implicit value (ex: iota)", id.Name))
+			return node
+		}
+		// the expression was parsed on its own, its positions would confuse the printer.
+		n.Values[i] = ast.NewIdent(types.ExprString(x))
+	}
+	return node
+}
+
+// rewriteConstExpr folds the constant expressions that depend on the constants that changed,
+// ex: `Shards - 1` becomes 63 with value:Shards=64.
+func (g *GenX) rewriteConstExpr(node *xast.Node) *xast.Node {
+	x := node.Node().(ast.Expr)
+	if len(g.constVals) == 0 {
+		return node
+	}
+
+	tv := g.info.Types[x]
+	if tv.Value == nil || !g.dependsOnConsts(x) {
+		return node
+	}
+
+	v, ok := g.evalConst(x)
+	if !ok {
+		return node
+	}
+	lit, ok := constLit(v)
+	if !ok {
+		return node
+	}
+
+	// untyped expressions and explicitly typed declarations can use the literal as is, everything else needs a conversion.
+	if vs, ok := node.Parent().Node().(*ast.ValueSpec); ok && vs.Type != nil {
+		return node.SetNode(lit)
+	}
+	switch t := g.constTypeOf(x).(type) {
+	case nil:
+		return node.SetNode(lit)
+	case *types.Basic:
+		return node.SetNode(&ast.CallExpr{Fun: ast.NewIdent(t.Name()), Args: []ast.Expr{lit}})
+	case *types.Named:
+		if t.Obj().Pkg() != g.pkg || t.TypeArgs().Len() > 0 {
+			return node
+		}
+		// the type itself might get renamed.
+		id := ast.NewIdent(t.Obj().Name())
+		g.info.Uses[id] = t.Obj()
+		return node.SetNode(&ast.CallExpr{Fun: id, Args: []ast.Expr{lit}})
+	}
+	return node
+}
@@ -0,0 +1,109 @@
+package genx
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// constraint is a `//genx:constraint T comparable` directive, it applies to every placeholder in names.
+type constraint struct {
+	names []string
+	expr  string
+	pos   token.Pos
+}
+
+var constraintRE = regexp.MustCompile(`^//\s*genx:constraint\s+(\w+(?:\s*,\s*\w+)*)\s+(\S.*?)\s*$`)
+
+// constraintAliases are the constraints that aren't valid Go on their own.
+var constraintAliases = map[string]string{
+	"ordered": "genx_cmp.Ordered",
+}
+
+// findConstraints returns the constraint directives of files.
+func findConstraints(files []*ast.File) (out []constraint) {
+	for _, f := range files {
+		for _, cg := range f.Comments {
+			for _, c := range cg.List {
+				m := constraintRE.FindStringSubmatch(c.Text)
+				if m == nil {
+					continue
+				}
+				var names []string
+				for _, n := range strings.Split(m[1], ",") {
+					if n = strings.TrimSpace(n); n != "" {
+						names = append(names, n)
+					}
+				}
+				out = append(out, constraint{names: names, expr: m[2], pos: c.Pos()})
+			}
+		}
+	}
+	return
+}
+
+// importsFile returns a file that imports everything the type arguments and the constraint aliases need,
+// so they can be evaluated in the template's package.
+func (g *GenX) importsFile(fset *token.FileSet, pkgName string) *ast.File {
+	paths := make([]string, 0, len(g.imports))
+	for p := range g.imports {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	src := "package " + pkgName + "\n\nimport genx_cmp \"cmp\"\n"
+	for _, p := range paths {
+		src += "import " + g.imports[p] + " " + strconv.Quote(p) + "\n"
+	}
+
+	f, err := parser.ParseFile(fset, "genx_imports.go", src, 0)
+	if err != nil {
+		g.fail(err)
+		return nil
+	}
+	return f
+}
+
+// checkConstraints checks the types the placeholders are replaced with against the template's constraint directives.
+func (g *GenX) checkConstraints(fset *token.FileSet, cons []constraint) {
+	for _, c := range cons {
+		cpos, expr := c.pos, c.expr
+		if alias, ok := constraintAliases[expr]; ok {
+			cpos, expr = g.argsPos, alias
+		}
+
+		ctv, err := types.Eval(fset, g.pkg, cpos, "interface{ "+expr+" }")
+		if err != nil {
+			g.fail(fmt.Errorf("%s: invalid constraint %s: %v (%s)", g.pkg.Name(), c.expr, err, fset.Position(c.pos)))
+			continue
+		}
+		iface := ctv.Type.Underlying().(*types.Interface)
+
+		for _, name := range c.names {
+			arg, ok := g.rewriters["type:"+name]
+			if !ok || arg == "-" {
+				continue
+			}
+
+			// types we can't resolve (ex: from a package that isn't imported yet) are left to the compiler.
+			tv, err := types.Eval(fset, g.pkg, g.argsPos, arg)
+			if err != nil {
+				continue
+			}
+			if !tv.IsType() {
+				g.fail(fmt.Errorf("%s: %s=%s isn't a type", g.pkg.Name(), name, arg))
+				continue
+			}
+
+			if !types.Satisfies(tv.Type, iface) {
+				g.fail(fmt.Errorf("%s: %s=%s doesn't satisfy %s (%s)", g.pkg.Name(), name, arg, c.expr, fset.Position(c.pos)))
+			}
+		}
+	}
+}
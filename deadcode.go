@@ -0,0 +1,382 @@
+package genx
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+)
+
+// depGraph is the reference graph of the template's package level declarations, methods and fields.
+type depGraph struct {
+	nodes   []types.Object                         // in declaration order
+	names   map[types.Object]string                // the name of the node in the template, methods and fields are prefixed by their type
+	pos     map[types.Object]token.Position        // where the node is declared
+	funcs   map[types.Object]bool                  // nodes declared by a FuncDecl
+	top     map[types.Object]bool                  // package level declarations
+	members map[types.Object][]types.Object        // type => its methods and fields
+	deps    map[types.Object]map[types.Object]bool // node => the nodes it refers to
+}
+
+// nodeOf returns the node obj belongs to, or nil if it isn't one (ex: locals, imports).
+func (dg *depGraph) nodeOf(obj types.Object) types.Object {
+	switch o := obj.(type) {
+	case *types.Func:
+		obj = o.Origin()
+	case *types.Var:
+		obj = o.Origin()
+	}
+	if _, ok := dg.names[obj]; !ok {
+		return nil
+	}
+	return obj
+}
+
+func (dg *depGraph) add(obj types.Object, name string, pos token.Position) {
+	if _, ok := dg.names[obj]; ok {
+		return
+	}
+	dg.nodes = append(dg.nodes, obj)
+	dg.names[obj], dg.pos[obj] = name, pos
+	dg.deps[obj] = map[types.Object]bool{}
+}
+
+// buildDepGraph builds the reference graph of files, it needs g.info.
+func (g *GenX) buildDepGraph(fset *token.FileSet, files []*ast.File) *depGraph {
+	dg := &depGraph{
+		names:   map[types.Object]string{},
+		pos:     map[types.Object]token.Position{},
+		funcs:   map[types.Object]bool{},
+		top:     map[types.Object]bool{},
+		members: map[types.Object][]types.Object{},
+		deps:    map[types.Object]map[types.Object]bool{},
+	}
+
+	// blank identifiers don't always get an object, but what they refer to still matters (ex: var _ I = T{}).
+	defOf := func(id *ast.Ident) types.Object {
+		if obj := g.info.Defs[id]; obj != nil {
+			return obj
+		}
+		return types.NewVar(id.Pos(), g.pkg, id.Name, nil)
+	}
+
+	// walks are deferred until every node is known.
+	type ref struct {
+		owner types.Object
+		n     ast.Node
+	}
+	var refs []ref
+	walk := func(owner types.Object, n ast.Node) {
+		refs = append(refs, ref{owner, n})
+	}
+
+	addMembers := func(typ types.Object, fl *ast.FieldList) {
+		if fl == nil {
+			return
+		}
+		for _, f := range fl.List {
+			names := f.Names
+			if len(names) == 0 {
+				if id := embeddedIdent(f.Type); id != nil && g.info.Defs[id] != nil {
+					names = []*ast.Ident{id}
+				}
+			}
+			if len(names) == 0 {
+				// embedded interfaces
+				walk(typ, f.Type)
+				continue
+			}
+			for _, n := range names {
+				obj := defOf(n)
+				dg.add(obj, dg.names[typ]+"."+n.Name, fset.Position(n.Pos()))
+				dg.members[typ] = append(dg.members[typ], obj)
+				dg.deps[obj][typ] = true
+				walk(obj, f.Type)
+			}
+		}
+	}
+
+	for _, f := range files {
+		for _, d := range f.Decls {
+			switch d := d.(type) {
+			case *ast.FuncDecl:
+				obj := defOf(d.Name)
+				name := d.Name.Name
+				var recv types.Object
+				if d.Recv != nil && len(d.Recv.List) == 1 {
+					x, _ := splitIndexExpr(d.Recv.List[0].Type)
+					if recv = g.info.Uses[getIdent(x)]; recv != nil {
+						name = recv.Name() + "." + name
+					}
+				}
+				dg.add(obj, name, fset.Position(d.Name.Pos()))
+				dg.funcs[obj] = true
+				if recv != nil {
+					dg.members[recv] = append(dg.members[recv], obj)
+				} else {
+					dg.top[obj] = true
+				}
+				walk(obj, d)
+
+			case *ast.GenDecl:
+				var values []ast.Expr
+				for _, s := range d.Specs {
+					switch s := s.(type) {
+					case *ast.TypeSpec:
+						obj := defOf(s.Name)
+						dg.add(obj, s.Name.Name, fset.Position(s.Name.Pos()))
+						dg.top[obj] = true
+						if s.TypeParams != nil {
+							walk(obj, s.TypeParams)
+						}
+						switch t := s.Type.(type) {
+						case *ast.StructType:
+							addMembers(obj, t.Fields)
+						case *ast.InterfaceType:
+							addMembers(obj, t.Methods)
+						default:
+							walk(obj, t)
+						}
+
+					case *ast.ValueSpec:
+						// consts without values repeat the previous ones (ex: iota).
+						if len(s.Values) > 0 || d.Tok != token.CONST {
+							values = s.Values
+						}
+						for _, n := range s.Names {
+							obj := defOf(n)
+							dg.add(obj, n.Name, fset.Position(n.Pos()))
+							dg.top[obj] = true
+							if s.Type != nil {
+								walk(obj, s.Type)
+							}
+							for _, v := range values {
+								walk(obj, v)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	for _, r := range refs {
+		ast.Inspect(r.n, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				if obj := dg.nodeOf(g.info.Uses[id]); obj != nil && obj != r.owner {
+					dg.deps[r.owner][obj] = true
+				}
+			}
+			return true
+		})
+	}
+
+	return dg
+}
+
+// dead returns the nodes that refer to any of roots, directly or not.
+func (dg *depGraph) dead(roots []types.Object) map[types.Object]bool {
+	out := map[types.Object]bool{}
+	for _, obj := range roots {
+		out[obj] = true
+	}
+
+	for changed := len(out) > 0; changed; {
+		changed = false
+		for _, obj := range dg.nodes {
+			if out[obj] {
+				continue
+			}
+			for dep := range dg.deps[obj] {
+				if out[dep] {
+					out[obj], changed = true, true
+					break
+				}
+			}
+		}
+	}
+	return out
+}
+
+// reachable returns the nodes that can be reached from the exported declarations, init and main,
+// ignoring the ones in dead.
+func (dg *depGraph) reachable(dead map[types.Object]bool) map[types.Object]bool {
+	out := map[types.Object]bool{}
+	var visit func(obj types.Object)
+	visit = func(obj types.Object) {
+		if out[obj] || dead[obj] {
+			return
+		}
+		out[obj] = true
+		for dep := range dg.deps[obj] {
+			visit(dep)
+		}
+		for _, m := range dg.members[obj] {
+			visit(m)
+		}
+	}
+
+	for _, obj := range dg.nodes {
+		if !dg.top[obj] {
+			continue
+		}
+		switch name := obj.Name(); {
+		case obj.Exported(), name == "init", name == "main", name == "_":
+			visit(obj)
+		}
+	}
+	return out
+}
+
+// kept returns the nodes named in keep (ex: Func, Type or Type.Method) and everything they refer to,
+// ignoring the ones in dead, along with the names it couldn't find.
+// Types always keep their fields, but only keep their methods if they're named or used.
+func (dg *depGraph) kept(keep []string, dead map[types.Object]bool) (out map[types.Object]bool, missing []string) {
+	byName := make(map[string]types.Object, len(dg.nodes))
+	for _, obj := range dg.nodes {
+		byName[dg.names[obj]] = obj
+	}
+
+	named := map[types.Object]bool{}
+	for _, name := range keep {
+		if obj, ok := byName[name]; ok {
+			named[obj] = true
+		} else {
+			missing = append(missing, name)
+		}
+	}
+
+	out = map[types.Object]bool{}
+	var visit func(obj types.Object)
+	visit = func(obj types.Object) {
+		if out[obj] || dead[obj] {
+			return
+		}
+		out[obj] = true
+		for dep := range dg.deps[obj] {
+			visit(dep)
+		}
+		for _, m := range dg.members[obj] {
+			if named[obj] || !dg.funcs[m] {
+				visit(m)
+			}
+		}
+	}
+
+	for _, obj := range dg.nodes {
+		if named[obj] {
+			visit(obj)
+		}
+	}
+	return
+}
+
+// findDeadDecls marks everything that depends on what the rewriters remove for removal,
+// along with the helpers that were only used by those, or everything g.Keep doesn't need if it's set.
+func (g *GenX) findDeadDecls(fset *token.FileSet, files []*ast.File) {
+	g.dead, g.removed = map[types.Object]bool{}, nil
+	if g.info == nil || g.pkg == nil {
+		return
+	}
+
+	dg := g.buildDepGraph(fset, files)
+
+	keys := map[types.Object]string{}
+	var roots []types.Object
+	for _, obj := range dg.nodes {
+		var key string
+		switch obj := obj.(type) {
+		case *types.Func:
+			if !dg.funcs[obj] {
+				break
+			}
+			// methods can be targeted by their receiver (ex: func:TSet.Keys).
+			if key = "func:" + dg.names[obj]; g.rewriters[key] == "" {
+				key = "func:" + obj.Name()
+			}
+		case *types.Var:
+			if obj.IsField() {
+				key = "field:" + obj.Name()
+			} else {
+				key = valueKey(obj)
+			}
+		case *types.Const:
+			key = valueKey(obj)
+		case *types.TypeName:
+			key = "type:" + obj.Name()
+		}
+		if _, ok := g.rewriters[key]; ok {
+			keys[obj] = key
+		}
+		if g.rewriters[key] == "-" {
+			roots = append(roots, obj)
+		}
+	}
+
+	if len(roots) == 0 && len(g.Keep) == 0 {
+		return
+	}
+
+	dead := dg.dead(roots)
+	before, after := dg.reachable(nil), dg.reachable(dead)
+	if len(g.Keep) > 0 {
+		var missing []string
+		if after, missing = dg.kept(g.Keep, dead); len(missing) > 0 {
+			g.fail(fmt.Errorf("can't keep %s: not declared in the template", strings.Join(missing, ", ")))
+		}
+		// everything else goes.
+		for _, obj := range dg.nodes {
+			before[obj] = true
+		}
+	}
+
+	for _, obj := range dg.nodes {
+		if dead[obj] || (before[obj] && !after[obj]) {
+			// the rewriters of removed declarations still matched something.
+			if key, ok := keys[obj]; ok {
+				g.use(key)
+			}
+			g.dead[obj] = true
+			g.removed = append(g.removed, fmt.Sprintf("%s (%s)", dg.names[obj], dg.pos[obj]))
+		}
+	}
+}
+
+// isDead reports whether the declaration of n has to be removed.
+func (g *GenX) isDead(n *ast.Ident) bool {
+	if g.info == nil || n == nil {
+		return false
+	}
+	return g.dead[g.info.Defs[n]]
+}
+
+// Removed returns the declarations, methods and fields that were removed from the template along with their
+// position, either by a rewriter or because they depended on something that was.
+func (g *GenX) Removed() []string {
+	return g.removed
+}
+
+// valueKey returns the rewriter key of a package level var or const (ex: var:expungedVT), or "" if obj isn't one.
+func valueKey(obj types.Object) string {
+	if obj == nil || obj.Pkg() == nil || obj.Parent() != obj.Pkg().Scope() {
+		return ""
+	}
+	switch obj.(type) {
+	case *types.Var:
+		return "var:" + obj.Name()
+	case *types.Const:
+		return "const:" + obj.Name()
+	}
+	return ""
+}
+
+// embeddedIdent returns the name of an embedded field, ex: *pkg.Type => Type.
+func embeddedIdent(x ast.Expr) *ast.Ident {
+	x, _ = splitIndexExpr(x)
+	if sel, ok := x.(*ast.SelectorExpr); ok {
+		return sel.Sel
+	}
+	id, _ := x.(*ast.Ident)
+	return id
+}
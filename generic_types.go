@@ -0,0 +1,74 @@
+// +build ignore
+
+package genx
+
+import "sync/atomic"
+
+// Map is a generic map of K to V.
+type Map[K comparable, V any] struct {
+	m    map[K]*entry[V]
+	last atomic.Pointer[V]
+	keys []K
+}
+
+type entry[V any] struct {
+	v V
+}
+
+type Pair[K comparable, V any] struct {
+	Key K
+	Val V
+}
+
+func NewMap[K comparable, V any]() *Map[K, V] {
+	return &Map[K, V]{m: map[K]*entry[V]{}}
+}
+
+// Set sets the value of key k to v.
+func (m *Map[K, V]) Set(k K, v V) {
+	m.m[k] = &entry[V]{v: v}
+	m.keys = append(m.keys, k)
+	m.last.Store(&v)
+}
+
+func (m *Map[KK, VV]) Get(k KK) (v VV, ok bool) {
+	e, ok := m.m[k]
+	if !ok {
+		return
+	}
+	return e.v, true
+}
+
+func (m *Map[K, V]) Pairs() []Pair[K, V] {
+	out := make([]Pair[K, V], 0, len(m.m))
+	for _, k := range m.keys {
+		v, _ := m.Get(k)
+		out = append(out, Pair[K, V]{Key: k, Val: v})
+	}
+	return out
+}
+
+func Keys[K comparable, V any](m *Map[K, V]) []K {
+	return m.keys
+}
+
+func Clone[K comparable, V any](m *Map[K, V]) *Map[K, V] {
+	out := NewMap[K, V]()
+	for _, p := range m.Pairs() {
+		out.Set(p.Key, p.Val)
+	}
+	_ = Keys(out)
+	return out
+}
+
+func Transform[V, O any](vs []V, fn func(V) O) []O {
+	out := make([]O, 0, len(vs))
+	for _, v := range vs {
+		out = append(out, fn(v))
+	}
+	return out
+}
+
+func Strings[V any](vs []V) []string {
+	return Transform[V, string](vs, func(V) string { return "" })
+}
@@ -0,0 +1,281 @@
+package genx
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"strings"
+
+	"github.com/OneOfOne/xast"
+)
+
+// prepareTypeParams maps the template's type parameters to the type rewriters that instantiate them,
+// type parameters are matched by the name used in the declaration of the generic type or func,
+// so methods are free to rename them in their receivers.
+func (g *GenX) prepareTypeParams(files []*ast.File) {
+	g.typeArgs = map[types.Object]string{}
+	g.typeParamNames = map[string]bool{}
+
+	add := func(id *ast.Ident, name string) {
+		if _, ok := g.rewriters["type:"+name]; !ok || g.rewriters["type:"+name] == "-" {
+			return
+		}
+		if obj := g.info.Defs[id]; obj != nil {
+			g.use("type:" + name)
+			g.typeArgs[obj] = name
+			if g.pkg.Scope().Lookup(name) == nil {
+				g.typeParamNames[name] = true
+			}
+		}
+	}
+
+	addList := func(fl *ast.FieldList) {
+		if fl == nil {
+			return
+		}
+		for _, f := range fl.List {
+			for _, n := range f.Names {
+				add(n, n.Name)
+			}
+		}
+	}
+
+	for _, f := range files {
+		for _, d := range f.Decls {
+			switch d := d.(type) {
+			case *ast.GenDecl:
+				for _, s := range d.Specs {
+					if ts, ok := s.(*ast.TypeSpec); ok {
+						addList(ts.TypeParams)
+					}
+				}
+			case *ast.FuncDecl:
+				addList(d.Type.TypeParams)
+				if d.Recv == nil || len(d.Recv.List) != 1 {
+					break
+				}
+				x, indices := splitIndexExpr(d.Recv.List[0].Type)
+				params := g.typeParamsOf(x)
+				if params == nil {
+					break
+				}
+				for i, idx := range indices {
+					if id, ok := idx.(*ast.Ident); ok && i < params.Len() {
+						add(id, params.At(i).Obj().Name())
+					}
+				}
+			}
+		}
+	}
+
+	// type parameters are usually single letters, replacing them inside other identifiers would mangle everything.
+	m := make(map[string]string, len(g.origRewriters))
+	for k, v := range g.origRewriters {
+		if !strings.HasPrefix(k, "type:") || !g.typeParamNames[k[5:]] {
+			m[k] = v
+		}
+	}
+	g.irepl = geireplacer(m, g.nameOf)
+
+	// comments only get the placeholders replaced, their other words might not be about the code.
+	for k := range m {
+		if !strings.HasPrefix(k, "type:") {
+			delete(m, k)
+		}
+	}
+	g.crepl = geireplacer(m, g.nameOf)
+}
+
+// typeParamsOf returns the type parameters of the generic type or func x refers to.
+func (g *GenX) typeParamsOf(x ast.Expr) *types.TypeParamList {
+	id, ok := x.(*ast.Ident)
+	if !ok {
+		return nil
+	}
+
+	switch obj := g.objectOf(id).(type) {
+	case *types.TypeName:
+		if obj.Pkg() != g.pkg {
+			return nil
+		}
+		if n, ok := obj.Type().(*types.Named); ok {
+			return n.TypeParams()
+		}
+	case *types.Func:
+		if obj.Pkg() != g.pkg {
+			return nil
+		}
+		return obj.Type().(*types.Signature).TypeParams()
+	}
+	return nil
+}
+
+// stripTypeParams removes the type parameters that have a type argument from fl,
+// it returns the type arguments in order and nil if the list ends up empty.
+func (g *GenX) stripTypeParams(fl *ast.FieldList) (_ *ast.FieldList, args []string) {
+	if fl == nil || g.info == nil {
+		return fl, nil
+	}
+
+	list := fl.List[:0]
+	for _, f := range fl.List {
+		names := f.Names[:0]
+		for _, n := range f.Names {
+			if name, ok := g.typeArgs[g.info.Defs[n]]; ok {
+				args = append(args, name)
+				continue
+			}
+			names = append(names, n)
+		}
+		if f.Names = names; len(names) > 0 {
+			list = append(list, f)
+		}
+	}
+
+	if fl.List = list; len(list) == 0 {
+		return nil, args
+	}
+	return fl, args
+}
+
+// instantiate removes the instantiated type arguments from x[indices...] and renames x if it's a generic type.
+func (g *GenX) instantiate(x ast.Expr, indices []ast.Expr) (_ ast.Expr, _ []ast.Expr, ok bool) {
+	params := g.typeParamsOf(x)
+	if params == nil {
+		return x, indices, false
+	}
+
+	var args []string
+	out := indices[:0]
+	for i, idx := range indices {
+		if i >= params.Len() {
+			out = append(out, idx)
+			continue
+		}
+		name, ok := g.typeArgs[params.At(i).Obj()]
+		if !ok {
+			out = append(out, idx)
+			continue
+		}
+		if arg := g.typeArgOf(idx); arg != g.rewriters["type:"+name] {
+			g.fail(fmt.Errorf("%s[%s]: %s is instantiated with %s, only %s is supported",
+				types.ExprString(x), types.ExprString(idx), name, arg, g.rewriters["type:"+name]))
+		}
+		args = append(args, name)
+	}
+
+	if len(args) == 0 {
+		return x, indices, false
+	}
+
+	id := x.(*ast.Ident)
+	if _, ok := g.objectOf(id).(*types.TypeName); ok {
+		id.Name = g.instanceName(id.Name, args)
+	}
+	return id, out, true
+}
+
+// instanceName returns the name of the instantiation of a generic type with the provided type parameters,
+// ex: Map[K, V] with K=string, V=int becomes MapStringInt.
+func (g *GenX) instanceName(name string, params []string) string {
+	for _, p := range params {
+		name += g.nameOf(g.origRewriters["type:"+p])
+	}
+	return name
+}
+
+// typeArgOf returns the type argument an index expression resolves to after rewriting.
+func (g *GenX) typeArgOf(x ast.Expr) string {
+	if id, ok := x.(*ast.Ident); ok {
+		if name, ok := g.typeArgs[g.objectOf(id)]; ok {
+			return g.rewriters["type:"+name]
+		}
+	}
+	return types.ExprString(x)
+}
+
+func splitIndexExpr(x ast.Expr) (ast.Expr, []ast.Expr) {
+	switch x := x.(type) {
+	case *ast.StarExpr:
+		return splitIndexExpr(x.X)
+	case *ast.IndexExpr:
+		return x.X, []ast.Expr{x.Index}
+	case *ast.IndexListExpr:
+		return x.X, x.Indices
+	}
+	return x, nil
+}
+
+func (g *GenX) rewriteIndexExpr(node *xast.Node) *xast.Node {
+	n := node.Node().(*ast.IndexExpr)
+	if g.info == nil {
+		return node
+	}
+	if _, ok := g.info.Instances[getIdent(n.X)]; !ok {
+		return node
+	}
+
+	x, indices, ok := g.instantiate(n.X, []ast.Expr{n.Index})
+	if !ok {
+		return node
+	}
+	if len(indices) == 0 {
+		return node.SetNode(x)
+	}
+	return node
+}
+
+func (g *GenX) rewriteIndexListExpr(node *xast.Node) *xast.Node {
+	n := node.Node().(*ast.IndexListExpr)
+	if g.info == nil {
+		return node
+	}
+	if _, ok := g.info.Instances[getIdent(n.X)]; !ok {
+		return node
+	}
+
+	x, indices, ok := g.instantiate(n.X, n.Indices)
+	if !ok {
+		return node
+	}
+
+	switch len(indices) {
+	case 0:
+		return node.SetNode(x)
+	case 1:
+		return node.SetNode(&ast.IndexExpr{X: x, Lbrack: n.Lbrack, Index: indices[0], Rbrack: n.Rbrack})
+	}
+	n.Indices = indices
+	return node
+}
+
+// rewriteRecvTypeParams instantiates the receiver of a method of a generic type.
+func (g *GenX) rewriteRecvTypeParams(recv *ast.Field) {
+	var (
+		expr = &recv.Type
+		star *ast.StarExpr
+	)
+
+	if star, _ = recv.Type.(*ast.StarExpr); star != nil {
+		expr = &star.X
+	}
+
+	x, indices := splitIndexExpr(*expr)
+	if len(indices) == 0 {
+		return
+	}
+
+	x, indices, ok := g.instantiate(x, indices)
+	if !ok {
+		return
+	}
+
+	switch len(indices) {
+	case 0:
+		*expr = x
+	case 1:
+		*expr = &ast.IndexExpr{X: x, Index: indices[0]}
+	default:
+		*expr = &ast.IndexListExpr{X: x, Indices: indices}
+	}
+}
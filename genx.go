@@ -4,16 +4,22 @@ import (
 	"bytes"
 	"fmt"
 	"go/ast"
-	"go/build"
+	"go/constant"
 	"go/parser"
 	"go/printer"
 	"go/token"
+	"go/types"
+	"io/fs"
 	"log"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
 
+	"github.com/OneOfOne/genx/seeds"
 	"github.com/OneOfOne/xast"
 
 	"golang.org/x/tools/go/ast/astutil"
@@ -24,27 +30,83 @@ type procFunc func(*xast.Node) *xast.Node
 type GenX struct {
 	pkgName        string
 	rewriters      map[string]string
-	irepl          *strings.Replacer
+	irepl          *identReplacer
+	crepl          *identReplacer // irepl limited to the type: rewriters, for comments
 	imports        map[string]string
-	zeroTypes      map[string]bool
-	curReturnTypes []string
 	visited        map[ast.Node]bool
+	info           *types.Info
+	pkg            *types.Package
+	origRewriters  map[string]string
+	typeArgs       map[types.Object]string
+	typeParamNames map[string]bool
+	symbols        symbolTable
+	used           map[string]bool
+	dead           map[types.Object]bool
+	methodRenames  map[types.Object]string
+	constSrc       map[string]string
+	constVals      map[types.Object]constant.Value
+	constExprs     map[types.Object]ast.Expr
+	fixed          map[types.Object]bool // the constants that don't depend on the placeholders
+	injectConsts   []string
+	nils           map[*ast.Ident]types.Type // the type each nil is used as
+	fset           *token.FileSet
+	importer       types.Importer // the imports of the parsed package
+	argsPos        token.Pos      // where the type arguments can be evaluated
+	removed        []string
+	renames        map[string]string
+	err            error
+
+	tmplErrs    map[string]bool           // the type errors of the template, by position and message
+	tmplImports map[string]token.Position // where the template imports each path
 
 	BuildTags      []string
 	CommentFilters []func(string) string
 
+	// GoFlags are extra flags for the go command used to load packages (ex: -mod=vendor).
+	GoFlags []string
+
+	// Env is added to the environment of the go command used to load packages (ex: GOPROXY=off to only use the
+	// module cache).
+	Env []string
+
+	// FS, if set, is where ParsePkg and Modernize read packages from, their path is a directory of FS
+	// (ex: set with seeds.FS), the external tests (package foo_test) are left out unless it's seeds.FS,
+	// since the import path of the other FSs' packages isn't known.
+	FS fs.FS
+
+	// Naming, if set, returns the name a type gets inside identifiers, comments and file names (ex: interface{} => Any),
+	// DefaultNaming is used when it's nil or returns "".
+	Naming func(typ string) string
+
+	// CheckOutput, if set, type-checks the output after rewriting (aka strict mode), any problem the template didn't
+	// already have is returned as OutputErrors instead of writing code that doesn't compile.
+	CheckOutput bool
+
+	// LineDirectives, if set, adds //line directives to the output so compilers, panics and coverage report the
+	// positions in the templates, their paths are made relative to LineDirectives, which should be the directory the
+	// output is written to (ex: "." for go generate).
+	LineDirectives string
+
+	// Keep, if set, limits the output to the listed funcs, types and methods (ex: NewSet, Set, Set.Has)
+	// and what they depend on, names are the ones used in the template.
+	Keep []string
+
 	rewriteFuncs map[reflect.Type][]procFunc
 }
 
 func New(pkgName string, rewriters map[string]string) *GenX {
+	rewriters = constValues(rewriters)
 	g := &GenX{
-		pkgName:   pkgName,
-		rewriters: map[string]string{},
-		imports:   map[string]string{},
-		visited:   map[ast.Node]bool{},
-		irepl:     geireplacer(rewriters, true),
-		zeroTypes: map[string]bool{},
-		BuildTags: []string{"genx"},
+		pkgName:       pkgName,
+		rewriters:     map[string]string{},
+		origRewriters: rewriters,
+		imports:       map[string]string{},
+		visited:       map[ast.Node]bool{},
+		irepl:         geireplacer(rewriters, DefaultNaming),
+		used:          map[string]bool{},
+		renames:       map[string]string{},
+		constSrc:      map[string]string{},
+		BuildTags:     []string{"genx"},
 	}
 
 	g.rewriteFuncs = map[reflect.Type][]procFunc{
@@ -57,28 +119,49 @@ func New(pkgName string, rewriters map[string]string) *GenX {
 		reflect.TypeOf((*ast.SelectorExpr)(nil)):  {g.rewriteSelectorExpr},
 		reflect.TypeOf((*ast.KeyValueExpr)(nil)):  {g.rewriteKeyValueExpr},
 		reflect.TypeOf((*ast.InterfaceType)(nil)): {g.rewriteInterfaceType},
-		reflect.TypeOf((*ast.ReturnStmt)(nil)):    {g.rewriteReturnStmt},
 		reflect.TypeOf((*ast.ArrayType)(nil)):     {g.rewriteArrayType},
 		reflect.TypeOf((*ast.ChanType)(nil)):      {g.rewriteChanType},
 		reflect.TypeOf((*ast.MapType)(nil)):       {g.rewriteMapType},
 		reflect.TypeOf((*ast.FuncType)(nil)):      {g.rewriteFuncType},
 		reflect.TypeOf((*ast.StarExpr)(nil)):      {g.rewriteStarExpr},
 		reflect.TypeOf((*ast.Ellipsis)(nil)):      {g.rewriteEllipsis},
+		reflect.TypeOf((*ast.IndexExpr)(nil)):     {g.rewriteIndexExpr},
+		reflect.TypeOf((*ast.IndexListExpr)(nil)): {g.rewriteIndexListExpr},
+		reflect.TypeOf((*ast.GenDecl)(nil)):       {g.rewriteGenDecl},
+		reflect.TypeOf((*ast.ValueSpec)(nil)):     {g.rewriteValueSpec},
+		reflect.TypeOf((*ast.BinaryExpr)(nil)):    {g.rewriteConstExpr},
+		reflect.TypeOf((*ast.UnaryExpr)(nil)):     {g.rewriteConstExpr},
+		reflect.TypeOf((*ast.ParenExpr)(nil)):     {g.rewriteConstExpr},
+		reflect.TypeOf((*ast.CallExpr)(nil)):      {g.rewriteCallExpr},
 	}
 
 	for k, v := range rewriters {
-		name, pkg, sel := parsePackageWithType(v)
-		if pkg != "" {
-			g.imports[pkg] = name
+		idx := strings.Index(k, ":")
+		typ, kw := k[:idx], k[idx+1:]
+		if isConstValue(k) {
+			g.constSrc[kw] = v
+			continue
 		}
 
-		if sel == "" {
-			sel = v
+		sel := v
+		if v != "-" {
+			src, imports, err := qualify(v)
+			if typ == "type" && err == nil {
+				var x ast.Expr
+				if x, _, imports, err = parseTypeArg(v); err == nil {
+					src = types.ExprString(x)
+				}
+			}
+			if err != nil {
+				g.fail(fmt.Errorf("%s=%s: %v", k, v, err))
+			} else {
+				sel = src
+			}
+			for pkg, name := range imports {
+				g.imports[pkg] = name
+			}
 		}
 
-		idx := strings.Index(k, ":")
-		typ, kw := k[:idx], k[idx+1:]
-
 		if v == "-" {
 			g.CommentFilters = append(g.CommentFilters, regexpReplacer(`\b`+kw+`\b`, ""))
 		} else {
@@ -88,13 +171,10 @@ func New(pkgName string, rewriters map[string]string) *GenX {
 			case "type":
 				csel := cleanUpName.ReplaceAllString(sel, "")
 				kw = cleanUpName.ReplaceAllString(kw, "")
-				if isBuiltin := csel != "interface" && builtins[csel] != ""; isBuiltin {
+				if isBuiltinType(sel) {
 					g.BuildTags = append(g.BuildTags, "genx_"+strings.ToLower(kw)+"_builtin")
 				}
 				g.BuildTags = append(g.BuildTags, "genx_"+strings.ToLower(kw)+"_"+csel)
-				g.zeroTypes[sel] = false
-				g.CommentFilters = append(g.CommentFilters, regexpReplacer(`\b(`+kw+`)\b`, sel))
-				g.CommentFilters = append(g.CommentFilters, regexpReplacer(`(`+kw+`)`, strings.Title(csel)))
 			}
 		}
 
@@ -108,50 +188,167 @@ func New(pkgName string, rewriters map[string]string) *GenX {
 // For more details about fname/src check `go/parser.ParseFile`
 func (g *GenX) Parse(fname string, src interface{}) (ParsedFile, error) {
 	fset := token.NewFileSet()
+	g.importer = nil
 	file, err := parser.ParseFile(fset, fname, src, parser.ParseComments)
 	if err != nil {
 		return ParsedFile{Name: fname}, err
 	}
 
-	return g.process(0, fset, fname, file)
+	if g.checkTypes(fset, []*ast.File{file}); g.err != nil {
+		return ParsedFile{Name: fname}, g.err
+	}
+	pf, err := g.process(0, fset, fname, file)
+	if g.CheckOutput && g.err == nil {
+		if cerr := g.checkOutput(ParsedPkg{pf}); cerr != nil {
+			err = cerr
+		}
+	}
+	if err == nil && g.LineDirectives != "" {
+		pf = pf.withLineDirectives(g.LineDirectives)
+	}
+	return pf, err
 }
 
 // ParsePKG will parse the provided package, on success it will then process the files with
 // x/tools/imports (goimports) then return the resulting package.
 func (g *GenX) ParsePkg(path string, includeTests bool) (out ParsedPkg, err error) {
-	ctx := build.Default
-	ctx.BuildTags = append(ctx.BuildTags, g.BuildTags...)
+	if out, err = g.parsePkg(path, includeTests); err == nil {
+		out = out.withLineDirectives(g.LineDirectives)
+	}
+	return
+}
 
-	pkg, err := ctx.ImportDir(path, build.IgnoreVendor)
+// parsePkg is ParsePkg without the //line directives, which have to be the last thing added to the output.
+func (g *GenX) parsePkg(path string, includeTests bool) (out ParsedPkg, err error) {
+	fset, files, astFiles, err := g.parseDir(path, includeTests)
 	if err != nil {
 		return nil, err
 	}
 
-	out = make(ParsedPkg, 0, len(pkg.GoFiles))
-	fset := token.NewFileSet()
+	out = make(ParsedPkg, 0, len(files))
 
-	files := append([]string{}, pkg.GoFiles...)
-	if includeTests {
-		files = append(files, pkg.TestGoFiles...)
+	if g.checkTypes(fset, astFiles); g.err != nil {
+		return nil, g.err
 	}
 
-	// TODO: process multiple files in the same time.
+	var procErr error
 	for i, name := range files {
-		var file *ast.File
-		if file, err = parser.ParseFile(fset, filepath.Join(pkg.Dir, name), nil, parser.ParseComments); err != nil {
-			return
-		}
 		var pf ParsedFile
-		if pf, err = g.process(i, fset, name, file); err != nil {
+		if pf, err = g.process(i, fset, name, astFiles[i]); err != nil {
+			// goimports failed, the output check says why.
+			if g.CheckOutput && g.err == nil {
+				out, procErr = append(out, pf), err
+				continue
+			}
 			log.Printf("%s", pf.Src)
 			return
 		}
 		out = append(out, pf)
 	}
+
+	if g.CheckOutput {
+		if err = g.checkOutput(out); err == nil {
+			err = procErr
+		}
+	}
+	return
+}
+
+// parseDir parses all the files of the package in path that match g.BuildTags,
+// the whole package has to be parsed before processing so it can be type-checked.
+func (g *GenX) parseDir(path string, includeTests bool) (fset *token.FileSet, names []string, files []*ast.File, err error) {
+	pkg, err := g.loadPkg(path, includeTests)
+	if err != nil {
+		return
+	}
+
+	pkgPath, xtests := pkg.path, pkg.xtests
+	if pkgPath == "" && g.FS == fs.FS(seeds.FS) {
+		// the embedded seeds are the only packages of an FS whose import path is known, ex: seeds/set.
+		pkgPath = seedsPath + "/" + pkg.dir
+	}
+	if pkgPath == "" {
+		// the external tests can't be told apart from the package they import.
+		xtests = nil
+	}
+
+	fset = token.NewFileSet()
+	files = make([]*ast.File, 0, len(pkg.files)+len(xtests))
+	for i, fn := range append(pkg.files, xtests...) {
+		var file *ast.File
+		var src []byte
+		if src, err = pkg.readFile(fn); err != nil {
+			return
+		}
+		if file, err = parser.ParseFile(fset, fn, src, parser.ParseComments); err != nil {
+			return
+		}
+		if i >= len(pkg.files) && len(files) > 0 {
+			if err = declClashes(fset, file, files[:len(pkg.files)]); err != nil {
+				return
+			}
+			internalizeTest(fset, file, files[0].Name.Name, pkgPath)
+		}
+		names, files = append(names, filepath.Base(fn)), append(files, file)
+	}
+	g.importer = pkgImporter(pkg.imports)
 	return
 }
 
-var removePkgAndImports = regexp.MustCompile(`package .*|import ".*|(?s:import \(.*?\)\n)`)
+// seedsPath is the import path of the directory of the embedded seeds.
+const seedsPath = "github.com/OneOfOne/genx/seeds"
+
+// internalizeTest turns the external test f (package foo_test) into a test of the package pkgName itself,
+// so it can be type-checked and rewritten along with the package in pkgPath, which it no longer imports.
+func internalizeTest(fset *token.FileSet, f *ast.File, pkgName, pkgPath string) {
+	f.Name.Name = pkgName
+
+	var local string
+	for _, is := range f.Imports {
+		if p, _ := strconv.Unquote(is.Path.Value); p != pkgPath {
+			continue
+		}
+		var name string
+		if local = pkgName; is.Name != nil {
+			name, local = is.Name.Name, is.Name.Name
+		}
+		astutil.DeleteNamedImport(fset, f, name, pkgPath)
+		break
+	}
+
+	if local == "" || local == "." {
+		return
+	}
+	astutil.Apply(f, nil, func(c *astutil.Cursor) bool {
+		// unresolved identifiers are the ones declared at the file level, aka the imports.
+		if sel, ok := c.Node().(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok && x.Name == local && x.Obj == nil {
+				c.Replace(sel.Sel)
+			}
+		}
+		return true
+	})
+}
+
+// declClashes returns an error if the external test f declares any of the package level names of files, the
+// files of the package it's merged into.
+func declClashes(fset *token.FileSet, f *ast.File, files []*ast.File) error {
+	var clashes []string
+	for name, obj := range f.Scope.Objects {
+		for _, pf := range files {
+			if other := pf.Scope.Objects[name]; other != nil {
+				clashes = append(clashes, fmt.Sprintf("%s (%s and %s)", name, fset.Position(obj.Pos()), fset.Position(other.Pos())))
+				break
+			}
+		}
+	}
+	if len(clashes) == 0 {
+		return nil
+	}
+	sort.Strings(clashes)
+	return fmt.Errorf("the external test %s declares names the package already has:\n\t%s",
+		fset.Position(f.Package).Filename, strings.Join(clashes, "\n\t"))
+}
 
 func (g *GenX) process(idx int, fset *token.FileSet, name string, file *ast.File) (pf ParsedFile, err error) {
 	for imp, name := range g.imports {
@@ -169,32 +366,65 @@ func (g *GenX) process(idx int, fset *token.FileSet, name string, file *ast.File
 		g.pkgName = file.Name.Name
 	}
 
+	if idx == 0 {
+		g.symbols = symbolTable{}
+	}
+	syms := templateSymbols(fset, file)
+
 	var buf bytes.Buffer
-	if err = printer.Fprint(&buf, fset, xast.Walk(file, g.rewrite)); err != nil {
+	file = xast.Walk(file, g.rewrite).(*ast.File)
+	if err = printer.Fprint(&buf, fset, file); err != nil {
 		return
 	}
 
-	if idx == 0 && len(g.zeroTypes) > 0 {
-		buf.WriteByte('\n')
-		for t, used := range g.zeroTypes {
-			if used {
-				fmt.Fprintf(&buf, "var zero_%s %s\n", cleanUpName.ReplaceAllString(t, ""), t)
-			}
-		}
+	g.checkCollisions(file, syms)
+
+	if err = g.err; err != nil {
+		pf.Name, pf.Src = name, buf.Bytes()
+		return
+	}
+
+	if idx == 0 && len(g.injectConsts) > 0 {
+		buf.WriteString("\n" + strings.Join(g.injectConsts, "\n") + "\n")
 	}
-	if pf.Src, err = imports.Process(name, buf.Bytes(), &imports.Options{
+
+	opts := &imports.Options{
 		AllErrors: true,
 		Comments:  true,
 		TabIndent: true,
 		TabWidth:  4,
-	}); err != nil {
+	}
+	if pf.Src, err = imports.Process(name, buf.Bytes(), opts); err != nil {
 		pf.Src = buf.Bytes()
+	} else if src, ok := pruneBranches(name, pf.Src, g.fixedNames(fset, file, name, pf.Src)); ok {
+		// the branches need the imports goimports adds, and can leave unused ones behind.
+		if pf.Src, err = imports.Process(name, src, opts); err != nil {
+			pf.Src = src
+		}
 	}
 
-	pf.Name = name
+	pf.Name = g.fileName(name)
+	if err == nil {
+		pf.Lines = mapLines(fset, []*ast.File{file}, name, pf.Src, true)
+	}
 	return
 }
 
+// fileName returns the output name of the template file name, the placeholders in it are replaced like in comments
+// with the lowercased names of their types, ex: T_set.go => iface_set.go.
+func (g *GenX) fileName(name string) string {
+	if g.crepl == nil {
+		return name
+	}
+	r := *g.crepl
+	r.vals = make(map[string]string, len(g.crepl.vals))
+	for k, v := range g.crepl.vals {
+		r.vals[k] = strings.ToLower(v)
+	}
+	dir, base := filepath.Split(name)
+	return dir + commentWordRE.ReplaceAllStringFunc(base, r.Replace)
+}
+
 func (g *GenX) rewrite(node *xast.Node) *xast.Node {
 	n := node.Node()
 	if g.visited[n] {
@@ -239,32 +469,106 @@ func (g *GenX) shouldNukeFuncBody(bs *ast.BlockStmt) (found bool) {
 			if x == nil {
 				break
 			}
-			if x.Obj != nil && x.Obj.Type != nil {
+			if ot := g.namedTypeOf(x); ot != "" {
+				if found = g.rewriters["type:"+ot] == "-"; found {
+					return false
+				}
+			} else if x.Obj != nil && x.Obj.Type != nil {
 				ot := getIdent(x.Obj.Type)
 				if found = ot != nil && g.rewriters["type:"+ot.Name] == "-"; found {
 					return false
 				}
 			}
-			if found = g.rewriters["field:"+n.Sel.Name] == "-"; found {
+			if found = g.rewriters["field:"+n.Sel.Name] == "-" && g.isField(n.Sel); found {
 				return false
 			}
 		case *ast.Ident:
-			if found = g.rewriters["type:"+n.Name] == "-"; found {
+			if found = g.rewriters["type:"+n.Name] == "-" && g.isPkgType(n); found {
 				return false
 			}
-			// TODO handle removed fields / funcs
 		}
 		return true
 	})
 	return
 }
 
+// fail records the first error that happens while rewriting.
+func (g *GenX) fail(err error) {
+	if g.err == nil {
+		g.err = err
+	}
+}
+
+// use marks the rewriter key as applied.
+func (g *GenX) use(key string) {
+	g.used[key] = true
+}
+
+// renameIdent replaces the rewriters' keys inside name and marks the ones it found as applied.
+func (g *GenX) renameIdent(name string) string {
+	replaced := map[string]bool{}
+	nn := g.irepl.replace(name, func(key string) { replaced[key] = true })
+	switch partial := g.irepl.partialMatches(name, replaced); len(partial) {
+	case 0:
+	case 1:
+		g.renames[name] = fmt.Sprintf("%s (not renamed: %s isn't a whole word, use __ to glue it)", name, partial[0])
+	default:
+		g.renames[name] = fmt.Sprintf("%s (not renamed: %s aren't whole words, use __ to glue them)", name, strings.Join(partial, ", "))
+	}
+	if nn == name {
+		return name
+	}
+	g.renames[name] = name + " => " + nn
+
+	for k := range g.origRewriters {
+		kw := k[strings.Index(k, ":")+1:]
+		if strings.HasPrefix(k, "type:") && g.typeParamNames[kw] {
+			continue
+		}
+		if replaced[kw] {
+			g.use(k)
+		}
+	}
+	return nn
+}
+
+// Renames returns how the placeholders renamed the identifiers of the parsed files so far, sorted by name
+// (ex: `NewKT => NewString`), along with the ones that contain a placeholder that isn't a whole word of them.
+func (g *GenX) Renames() []string {
+	out := make([]string, 0, len(g.renames))
+	for _, r := range g.renames {
+		out = append(out, r)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Unused returns the sorted keys of the rewriters that didn't match anything in the parsed files so far,
+// usually a typo or a template that changed.
+func (g *GenX) Unused() (out []string) {
+	for k := range g.origRewriters {
+		if g.used[k] {
+			continue
+		}
+		if strings.HasPrefix(k, "field:") && g.used["selector:."+k[6:]] {
+			continue
+		}
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return
+}
+
 func getIdent(ex interface{}) *ast.Ident {
 	switch ex := ex.(type) {
 	case *ast.Ident:
 		return ex
 	case *ast.StarExpr:
 		return getIdent(ex.X)
+	case *ast.IndexExpr:
+		return getIdent(ex.X)
+	case *ast.IndexListExpr:
+		return getIdent(ex.X)
 	default:
 		return nil
 	}
@@ -272,22 +576,209 @@ func getIdent(ex interface{}) *ast.Ident {
 
 var cleanUpName = regexp.MustCompile(`[^\w\d_]+`)
 
-func geireplacer(m map[string]string, ident bool) *strings.Replacer {
-	kv := make([]string, 0, len(m)*2)
+// identReplacer replaces the placeholders inside identifiers, only where they're a whole word of it (ex: the KT of
+// NewKT or KT_Map, but not the T of Tree), `__` glues a placeholder to the rest of a word and gets removed
+// (ex: SortT__s => SortInts).
+type identReplacer struct {
+	keys  []string // longest first
+	vals  map[string]string
+	words []string // other names that delimit words, ex: the VT of KTVT when only KT is replaced
+
+	noPlurals bool // placeholders followed by an s (ex: SortTs) aren't whole words
+}
+
+// geireplacer returns a replacer for the keys of the rewriters m, name returns the name of a type inside identifiers,
+// the values are used as is if it's nil.
+func geireplacer(m map[string]string, name func(string) string) *identReplacer {
+	r := &identReplacer{vals: make(map[string]string, len(m))}
 	for k, v := range m {
+		// removals and values aren't renames, NewKT shouldn't become New because KT is removed.
+		if v == "-" || isConstValue(k) {
+			continue
+		}
 		k = k[strings.Index(k, ":")+1:]
-		if ident {
+		if name != nil {
+			v = name(v)
+		}
+		if _, ok := r.vals[k]; !ok {
+			r.keys = append(r.keys, k)
+		}
+		r.vals[k] = v
+	}
+	sort.Slice(r.keys, func(i, j int) bool {
+		if len(r.keys[i]) != len(r.keys[j]) {
+			return len(r.keys[i]) > len(r.keys[j])
+		}
+		return r.keys[i] < r.keys[j]
+	})
+	return r
+}
 
-			if a := builtins[v]; a != "" {
-				v = a
-			} else {
-				v = cleanUpName.ReplaceAllString(strings.Title(v), "")
+func (r *identReplacer) Replace(name string) string {
+	return r.replace(name, nil)
+}
+
+// replace calls fn with every placeholder it replaces in name.
+func (r *identReplacer) replace(name string, fn func(key string)) string {
+	var (
+		out  []byte
+		last = -1 // where the last replaced placeholder ended
+	)
+	for i := 0; i < len(name); {
+		key := r.match(name, i, i == last)
+		if key == "" {
+			if out != nil {
+				out = append(out, name[i])
 			}
+			i++
+			continue
 		}
 
-		kv = append(kv, k, v)
+		if out == nil {
+			out = append(make([]byte, 0, len(name)+16), name[:i]...)
+		}
+		out = append(bytes.TrimSuffix(out, []byte("__")), r.vals[key]...)
+		if i += len(key); strings.HasPrefix(name[i:], "__") {
+			i += 2
+		}
+		last = i
+		if fn != nil {
+			fn(key)
+		}
+	}
+	if out == nil {
+		return name
+	}
+	return string(out)
+}
+
+// match returns the longest placeholder that starts at name[i] and is a whole word of it,
+// glued is true if it directly follows a placeholder that was replaced.
+func (r *identReplacer) match(name string, i int, glued bool) string {
+	for _, k := range r.keys {
+		if !strings.HasPrefix(name[i:], k) {
+			continue
+		}
+		if !glued && !isWordStart(name, i) {
+			continue
+		}
+		// placeholders can follow each other, ex: KTVT.
+		if end := i + len(k); isWordEnd(name, end) || r.pluralAt(name, end) || acronymAt(name, end, k) || r.match(name, end, true) != "" || r.wordAt(name, end) {
+			return k
+		}
+	}
+	return ""
+}
+
+// wordAt reports whether one of r.words is a whole word of name that starts at i.
+func (r *identReplacer) wordAt(name string, i int) bool {
+	for _, w := range r.words {
+		if strings.HasPrefix(name[i:], w) && isWordEnd(name, i+len(w)) {
+			return true
+		}
+	}
+	return false
+}
+
+// pluralAt reports whether the s of a plural is at name[i], ex: the s of SortTs or TsAreSorted but not the one of Tset.
+func (r *identReplacer) pluralAt(name string, i int) bool {
+	return !r.noPlurals && i < len(name) && name[i] == 's' && isWordEnd(name, i+1)
+}
+
+// acronymAt reports whether an acronym of at least two letters starts at name[i], after the placeholder k if it has
+// at least two letters too, ex: the VT of KTVT, but not the X of KTX or the CP of TCPConn.
+func acronymAt(name string, i int, k string) bool {
+	if len(k) < 2 {
+		return false
+	}
+	j := i
+	for j < len(name) && unicode.IsUpper(rune(name[j])) {
+		j++
+	}
+	if j < len(name) && unicode.IsLower(rune(name[j])) {
+		// the last letter starts the next word, ex: the S of KTVTSet.
+		j--
+	}
+	return j-i >= 2
+}
+
+// partialMatches returns the placeholders that are in name without being a whole word of it, ex: T in Tree.
+func (r *identReplacer) partialMatches(name string, replaced map[string]bool) (out []string) {
+	for _, k := range r.keys {
+		if !replaced[k] && strings.Contains(name, k) {
+			out = append(out, k)
+		}
+	}
+	return
+}
+
+// isWordStart reports whether a word of name can start at i, ex: the T of TSet, NewT or HTTPTx,
+// but not the ones of Tree or HTTP.
+func isWordStart(name string, i int) bool {
+	if i == 0 || i >= len(name) {
+		return true
+	}
+	prev, cur := rune(name[i-1]), rune(name[i])
+	switch {
+	case !unicode.IsLetter(prev):
+		return true
+	case !unicode.IsUpper(cur):
+		return false
+	case !unicode.IsUpper(prev):
+		return true
+	}
+	// the last letter of an acronym starts the next word, ex: the S of HTTPServer.
+	return i+1 < len(name) && unicode.IsLower(rune(name[i+1]))
+}
+
+// isWordEnd reports whether a word of name can end at i, ex: the T of TSet or TX but not the ones of Tree or TCP.
+func isWordEnd(name string, i int) bool {
+	if i <= 0 || i >= len(name) {
+		return true
+	}
+	last, next := rune(name[i-1]), rune(name[i])
+	switch {
+	case !unicode.IsLetter(next):
+		return true
+	case !unicode.IsUpper(next):
+		return false
+	case !unicode.IsUpper(last):
+		return true
+	}
+	return isWordStart(name, i) || i+1 == len(name)
+}
+
+// nameOf returns the name used for type t inside of identifiers, comments and file names.
+func (g *GenX) nameOf(t string) string {
+	if g.Naming != nil {
+		if n := g.Naming(t); n != "" {
+			return n
+		}
+	}
+	return DefaultNaming(t)
+}
+
+// DefaultNaming returns the name genx uses for type t inside of identifiers,
+// ex: []byte => Bytes, *pkg.Type => PkgType, map[string][]int => MapStringIntSlice.
+func DefaultNaming(t string) string {
+	if a := builtins[t]; a != "" {
+		return a
+	}
+	if x, _, _, err := parseTypeArg(t); err == nil {
+		return typeName(x)
+	}
+	return cleanUpName.ReplaceAllString(strings.Title(t), "")
+}
+
+// NamingTable returns a Naming func that uses the names in table (ex: interface{} => Any, *pkg.Foo => Foo)
+// and DefaultNaming for the types that aren't in it.
+func NamingTable(table map[string]string) func(typ string) string {
+	return func(t string) string {
+		if n := table[t]; n != "" {
+			return n
+		}
+		return DefaultNaming(t)
 	}
-	return strings.NewReplacer(kv...)
 }
 
 var builtins = map[string]string{
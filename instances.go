@@ -0,0 +1,253 @@
+package genx
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/imports"
+)
+
+// ParsePkgInstances parses the package in path once for every GenX, each one being a different instantiation
+// of the same template, and returns all of them as a single package.
+// Declarations that end up identical in every instantiation (ex: helpers that don't use the placeholders) are only
+// kept once, declarations that have the same name but differ get the instantiation's name appended to theirs
+// (ex: `NewMap` => `NewMapStringInt`), anything that still collides after that is returned as an error.
+func ParsePkgInstances(path string, includeTests bool, gs ...*GenX) (out ParsedPkg, err error) {
+	insts := make([]*instance, 0, len(gs))
+	for _, g := range gs {
+		var pkg ParsedPkg
+		if pkg, err = g.parsePkg(path, includeTests); err != nil {
+			return nil, err
+		}
+		inst := &instance{suffix: g.instanceSuffix(), fset: token.NewFileSet(), lines: map[string]LineMap{}}
+		for _, pf := range pkg {
+			inst.lines[pf.Name] = pf.Lines
+			var f *ast.File
+			if f, err = parser.ParseFile(inst.fset, pf.Name, pf.Src, parser.ParseComments); err != nil {
+				return nil, fmt.Errorf("instance %s: %v", inst.suffix, err)
+			}
+			inst.names, inst.files = append(inst.names, pf.Name), append(inst.files, f)
+		}
+		insts = append(insts, inst)
+	}
+
+	// same name, different declarations, those get renamed per instance.
+	decls := map[string]string{}
+	renames := map[string]bool{}
+	for _, inst := range insts {
+		for _, f := range inst.files {
+			for key, src := range inst.declsOf(f) {
+				if prev, ok := decls[key]; ok && prev != src && !strings.Contains(key, ".") {
+					renames[key] = true
+				}
+				decls[key] = src
+			}
+		}
+	}
+
+	for _, inst := range insts {
+		inst.rename(renames)
+	}
+
+	// identical declarations are only kept once.
+	seen := map[string]*instance{}
+	seenSrc := map[string]string{}
+	var collisions []string
+	for _, inst := range insts {
+		for _, f := range inst.files {
+			inst.filterDecls(f, func(key, src string) bool {
+				prev, ok := seen[key]
+				if !ok {
+					seen[key], seenSrc[key] = inst, src
+					return true
+				}
+				if seenSrc[key] != src {
+					collisions = append(collisions, fmt.Sprintf("%s is declared differently by %s and %s", key, prev.suffix, inst.suffix))
+				}
+				return false
+			})
+		}
+	}
+
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		return nil, fmt.Errorf("colliding declarations:\n\t%s", strings.Join(collisions, "\n\t"))
+	}
+
+	for _, inst := range insts {
+		for i, f := range inst.files {
+			if !hasDecls(f) {
+				continue
+			}
+
+			var buf bytes.Buffer
+			if err = printer.Fprint(&buf, inst.fset, f); err != nil {
+				return
+			}
+
+			name := inst.names[i]
+			ext := filepath.Ext(name)
+			if strings.HasSuffix(name, "_test.go") {
+				ext = "_test.go"
+			}
+			pf := ParsedFile{Name: strings.TrimSuffix(name, ext) + "_" + strings.ToLower(inst.suffix) + ext}
+			if pf.Src, err = imports.Process(pf.Name, buf.Bytes(), &imports.Options{
+				AllErrors: true,
+				Comments:  true,
+				TabIndent: true,
+				TabWidth:  4,
+			}); err != nil {
+				pf.Src = buf.Bytes()
+				return append(out, pf), err
+			}
+			pf.Lines = throughLines(mapLines(inst.fset, []*ast.File{f}, pf.Name, pf.Src, false), inst.lines)
+			out = append(out, pf)
+		}
+	}
+
+	if gs[0].CheckOutput {
+		if err = gs[0].checkOutput(out); err != nil {
+			return
+		}
+	}
+	return out.withLineDirectives(gs[0].LineDirectives), nil
+}
+
+// instanceSuffix returns the name of this instantiation, built from the type rewriters sorted by placeholder,
+// ex: KT=string,VT=int => StringInt.
+func (g *GenX) instanceSuffix() string {
+	keys := make([]string, 0, len(g.origRewriters))
+	for k, v := range g.origRewriters {
+		if strings.HasPrefix(k, "type:") && v != "-" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var name string
+	for _, k := range keys {
+		name += g.nameOf(g.origRewriters[k])
+	}
+	return name
+}
+
+// hasDecls reports whether f declares anything besides imports.
+func hasDecls(f *ast.File) bool {
+	for _, d := range f.Decls {
+		if gd, ok := d.(*ast.GenDecl); !ok || gd.Tok != token.IMPORT {
+			return true
+		}
+	}
+	return false
+}
+
+type instance struct {
+	suffix string
+	fset   *token.FileSet
+	names  []string
+	files  []*ast.File
+	lines  map[string]LineMap // name => the template positions of the lines of the processed file
+}
+
+// declKey returns the name a top level declaration is known by, methods are prefixed by their receiver's type.
+func declKey(recv *ast.FieldList, name string) string {
+	if recv != nil && len(recv.List) == 1 {
+		if t := getIdent(recv.List[0].Type); t != nil {
+			return t.Name + "." + name
+		}
+	}
+	return name
+}
+
+func (inst *instance) src(n ast.Node) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, inst.fset, n)
+	return buf.String()
+}
+
+func (inst *instance) declsOf(f *ast.File) map[string]string {
+	out := map[string]string{}
+	inst.filterDecls(f, func(key, src string) bool {
+		out[key] = src
+		return true
+	})
+	return out
+}
+
+// filterDecls calls fn for every top level declaration of f and removes the ones it returns false for.
+func (inst *instance) filterDecls(f *ast.File, fn func(key, src string) bool) {
+	cmap := ast.NewCommentMap(inst.fset, f, f.Comments)
+	decls := f.Decls[:0]
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			// a package can have as many init funcs as it wants.
+			if d.Recv == nil && d.Name.Name == "init" {
+				break
+			}
+			if !fn(declKey(d.Recv, d.Name.Name), inst.src(d)) {
+				continue
+			}
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT {
+				break
+			}
+			specs := d.Specs[:0]
+			for _, s := range d.Specs {
+				keep := true
+				switch s := s.(type) {
+				case *ast.TypeSpec:
+					keep = fn(s.Name.Name, inst.src(s))
+				case *ast.ValueSpec:
+					for _, n := range s.Names {
+						if n.Name != "_" {
+							keep = fn(n.Name, inst.src(s)) && keep
+						}
+					}
+				}
+				if keep {
+					specs = append(specs, s)
+				}
+			}
+			if d.Specs = specs; len(specs) == 0 {
+				continue
+			}
+		}
+		decls = append(decls, d)
+	}
+	f.Decls = decls
+	f.Comments = cmap.Filter(f).Comments()
+}
+
+// rename appends the instance's suffix to every package level declaration in names and updates all its uses.
+func (inst *instance) rename(names map[string]bool) {
+	if len(names) == 0 {
+		return
+	}
+
+	info := &types.Info{
+		Defs: map[*ast.Ident]types.Object{},
+		Uses: map[*ast.Ident]types.Object{},
+	}
+	conf := types.Config{
+		Importer: sharedImporter{},
+		Error:    func(error) {},
+	}
+	pkg, _ := conf.Check(inst.files[0].Name.Name, inst.fset, inst.files, info)
+
+	for _, m := range []map[*ast.Ident]types.Object{info.Defs, info.Uses} {
+		for id, obj := range m {
+			if obj != nil && obj.Parent() == pkg.Scope() && names[obj.Name()] {
+				id.Name = obj.Name() + inst.suffix
+			}
+		}
+	}
+}
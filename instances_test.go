@@ -0,0 +1,230 @@
+package genx_test
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/OneOfOne/genx"
+	"github.com/OneOfOne/genx/seeds"
+)
+
+func TestParsePkgInstances(t *testing.T) {
+	var gs []*genx.GenX
+	for _, typ := range []string{"string", "int", "uint64"} {
+		gs = append(gs, genx.New("set", map[string]string{
+			"type:T":       typ,
+			"func:NewTSet": "New",
+		}))
+	}
+
+	pkg, err := genx.ParsePkgInstances("./seeds/set", false, gs...)
+	fatalIf(t, err)
+
+	typeCheck(t, "set", pkg)
+
+	merged, err := pkg.MergeAll(false)
+	fatalIf(t, err)
+
+	for _, re := range []string{`type StringSet map`, `type Uint64Set map`, `func NewInt\(\) IntSet`, `func \(s Uint64Set\) Keys\(\)`} {
+		if !regexp.MustCompile(re).Match(merged.Src) {
+			t.Errorf("%s didn't match:\n%s", re, merged.Src)
+		}
+	}
+}
+
+func TestInstanceNaming(t *testing.T) {
+	naming := genx.NamingTable(map[string]string{"uint64": "U64"})
+	var gs []*genx.GenX
+	for _, typ := range []string{"string", "uint64"} {
+		g := genx.New("set", map[string]string{"type:T": typ})
+		g.Naming = naming
+		gs = append(gs, g)
+	}
+
+	pkg, err := genx.ParsePkgInstances("./seeds/set", false, gs...)
+	fatalIf(t, err)
+
+	var names []string
+	for _, pf := range pkg {
+		names = append(names, filepath.Base(pf.Name))
+		if strings.HasSuffix(pf.Name, "_u64.go") && !regexp.MustCompile(`type U64Set map`).Match(pf.Src) {
+			t.Errorf("expected U64Set in %s:\n%s", pf.Name, pf.Src)
+		}
+	}
+	if exp := "set_string.go set_u64.go"; strings.Join(names, " ") != exp {
+		t.Errorf("expected %s, got %s", exp, strings.Join(names, " "))
+	}
+}
+
+func TestFileNaming(t *testing.T) {
+	fsys := fstest.MapFS{
+		"x/T_set.go": {Data: []byte("package x\n\ntype T interface{}\n\ntype TSet map[T]struct{}\n")},
+		"x/Tree.go":  {Data: []byte("package x\n\ntype Tree struct{ v T }\n")},
+	}
+	for exp, naming := range map[string]func(string) string{
+		"Tree.go iface_set.go": nil,
+		"Tree.go any_set.go":   genx.NamingTable(map[string]string{"interface{}": "Any"}),
+	} {
+		g := genx.New("", map[string]string{"type:T": "interface{}"})
+		g.FS, g.Naming = fsys, naming
+		pkg, err := g.ParsePkg("x", false)
+		fatalIf(t, err)
+
+		var names []string
+		for _, pf := range pkg {
+			names = append(names, pf.Name)
+		}
+		sort.Strings(names)
+		if got := strings.Join(names, " "); got != exp {
+			t.Errorf("expected %s, got %s", exp, got)
+		}
+	}
+}
+
+func TestParsePkgInstancesTests(t *testing.T) {
+	for _, fsys := range []fs.FS{nil, seeds.FS} {
+		path := "./seeds/set"
+		var gs []*genx.GenX
+		for _, typ := range []string{"string", "int"} {
+			g := genx.New("set", map[string]string{"type:T": typ})
+			if g.FS = fsys; fsys != nil {
+				path = "set"
+			}
+			gs = append(gs, g)
+		}
+
+		pkg, err := genx.ParsePkgInstances(path, true, gs...)
+		fatalIf(t, err)
+
+		typeCheck(t, "set", pkg)
+
+		merged, err := pkg.MergeAll(true)
+		fatalIf(t, err)
+
+		for _, re := range []string{`(?m)^package set$`, `func TestStringSet\(t \*testing\.T\)`, `func TestIntSet\(`, `func ExampleIntSet\(\)`, `s := NewStringSet\(\)`} {
+			if !regexp.MustCompile(re).Match(merged.Src) {
+				t.Errorf("%s: %s didn't match:\n%s", path, re, merged.Src)
+			}
+		}
+		if bytes.Contains(merged.Src, []byte("set.")) {
+			t.Errorf("%s: the tests still use the template package:\n%s", path, merged.Src)
+		}
+	}
+}
+
+func TestExternalTests(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"go.mod":       "module example.com/x\n\ngo 1.21\n",
+		"x.go":         "package x\n\ntype T interface{}\n\nfunc Zero() (z T) { return }\n",
+		"x_test.go":    "package x\n\nimport \"testing\"\n\nfunc check(t *testing.T) {}\n",
+		"xext_test.go": "package x_test\n\nimport (\n\t\"testing\"\n\n\t\"example.com/x\"\n)\n\nfunc check(t *testing.T) {}\n\nfunc TestZero(t *testing.T) { _ = x.Zero() }\n",
+	} {
+		fatalIf(t, os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644))
+	}
+
+	_, err := genx.New("", map[string]string{"type:T": "int"}).ParsePkg(dir, true)
+	if re := regexp.MustCompile(`xext_test\.go declares names the package already has:\n\tcheck \(.*xext_test\.go:9:6 and .*x_test\.go:5:6\)$`); err == nil || !re.MatchString(err.Error()) {
+		t.Fatalf("%s didn't match %v", re, err)
+	}
+
+	// without an import path, the external tests can't be told apart from a package with the same name.
+	fsys := fstest.MapFS{
+		"x/x.go":         {Data: []byte("package x\n\ntype T interface{}\n")},
+		"x/xext_test.go": {Data: []byte("package x_test\n\nimport \"example.com/other/x\"\n\nvar _ = x.Other\n")},
+	}
+	g := genx.New("", map[string]string{"type:T": "int"})
+	g.FS = fsys
+	pkg, err := g.ParsePkg("x", true)
+	fatalIf(t, err)
+	if len(pkg) != 1 || pkg[0].Name != "x.go" {
+		t.Fatalf("expected x.go only, got %s", pkg)
+	}
+}
+
+func TestParsedFileLines(t *testing.T) {
+	var gs []*genx.GenX
+	for _, typ := range []string{"string", "int"} {
+		gs = append(gs, genx.New("set", map[string]string{"type:T": typ}))
+	}
+	pkg, err := genx.ParsePkgInstances("./seeds/set", true, gs...)
+	fatalIf(t, err)
+
+	tmpl := map[string][]string{}
+	for _, pf := range pkg {
+		for i, line := range strings.Split(string(pf.Src), "\n") {
+			if !strings.Contains(line, "Has(") {
+				continue
+			}
+			pos, ok := pf.Lines[i+1]
+			if !ok {
+				t.Fatalf("%s:%d isn't mapped:\n%s", pf.Name, i+1, pf.Lines)
+			}
+			if tmpl[pos.Filename] == nil {
+				src, err := os.ReadFile(pos.Filename)
+				fatalIf(t, err)
+				tmpl[pos.Filename] = strings.Split(string(src), "\n")
+			}
+			if tl := tmpl[pos.Filename][pos.Line-1]; !strings.Contains(tl, "Has(") {
+				t.Errorf("%s:%d %q => %s %q", pf.Name, i+1, line, pos, tl)
+			}
+		}
+	}
+	if len(tmpl) != 3 {
+		t.Errorf("expected lines from set.go, set_test.go and example_test.go, got %d files", len(tmpl))
+	}
+}
+
+func TestLineDirectives(t *testing.T) {
+	var gs []*genx.GenX
+	for _, typ := range []string{"string", "int"} {
+		g := genx.New("set", map[string]string{"type:T": typ})
+		g.LineDirectives = "."
+		gs = append(gs, g)
+	}
+	pkg, err := genx.ParsePkgInstances("./seeds/set", false, gs...)
+	fatalIf(t, err)
+
+	tmpl, err := os.ReadFile("./seeds/set/set.go")
+	fatalIf(t, err)
+	tmplLines := strings.Split(string(tmpl), "\n")
+
+	merged, err := pkg.MergeAll(false)
+	fatalIf(t, err)
+
+	for _, pf := range append(pkg, merged) {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, pf.Name, pf.Src, 0)
+		fatalIf(t, err)
+
+		for _, d := range f.Decls {
+			fd, ok := d.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			// the directives point the compiler at the template.
+			pos := fset.Position(fd.Pos())
+			if pos.Filename != filepath.Join("seeds", "set", "set.go") || !strings.HasPrefix(tmplLines[pos.Line-1], "func") {
+				t.Errorf("%s: %s is at %s", pf.Name, fd.Name.Name, pos)
+			}
+
+			// and so does the table.
+			line := fset.PositionFor(fd.Body.Rbrace, false).Line
+			if tp, ok := pf.Lines.Lookup(line); !ok || !strings.HasSuffix(tmplLines[tp.Line-1], "}") {
+				t.Errorf("%s:%d: %s's closing brace is at %s", pf.Name, line, fd.Name.Name, tp)
+			} else if gl := pf.Lines.Generated(tp.Filename, tp.Line); len(gl) == 0 {
+				t.Errorf("%s: no lines came from %s", pf.Name, tp)
+			}
+		}
+	}
+}
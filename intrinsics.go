@@ -0,0 +1,240 @@
+package genx
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strconv"
+
+	"github.com/OneOfOne/xast"
+)
+
+const intrinsicsPath = "github.com/OneOfOne/genx/intrinsics"
+
+// usesIntrinsics reports whether any of files imports the intrinsics package.
+func usesIntrinsics(files []*ast.File) bool {
+	for _, f := range files {
+		for _, imp := range f.Imports {
+			if p, _ := strconv.Unquote(imp.Path.Value); p == intrinsicsPath {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// intrinsicCall returns the name of the intrinsics func n calls (ex: Less) along with its type arguments,
+// or "" if it doesn't call one.
+func (g *GenX) intrinsicCall(n *ast.CallExpr) (string, []ast.Expr) {
+	fun, targs := splitIndexExpr(n.Fun)
+	sel, ok := fun.(*ast.SelectorExpr)
+	if !ok || g.info == nil {
+		return "", nil
+	}
+	x, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return "", nil
+	}
+	if pn, ok := g.info.Uses[x].(*types.PkgName); !ok || pn.Imported().Path() != intrinsicsPath {
+		return "", nil
+	}
+	return sel.Sel.Name, targs
+}
+
+// evalType returns the type src refers to in the template's package, or nil if it can't be resolved.
+func (g *GenX) evalType(src string) types.Type {
+	if g.pkg == nil {
+		return nil
+	}
+	tv, err := types.Eval(g.fset, g.pkg, g.argsPos, src)
+	if err != nil || !tv.IsType() {
+		return nil
+	}
+	return tv.Type
+}
+
+// concreteType returns the type t ends up as once the placeholders are replaced along with how it's written,
+// the type is nil if it can't be resolved (ex: from a package that isn't imported yet).
+func (g *GenX) concreteType(t types.Type) (string, types.Type) {
+	var name string
+	switch tt := t.(type) {
+	case nil:
+		return "", nil
+	case *types.TypeParam:
+		name = g.typeArgs[tt.Obj()]
+	case *types.Named:
+		if obj := tt.Obj(); obj.Pkg() == g.pkg && obj.Parent() == g.pkg.Scope() {
+			name = obj.Name()
+		}
+	}
+
+	if src := g.rewriters["type:"+name]; name != "" && src != "" && src != "-" {
+		return src, g.evalType(src)
+	}
+	return types.TypeString(t, types.RelativeTo(g.pkg)), t
+}
+
+// rewriteCallExpr expands the calls to the intrinsics package for the types the placeholders are replaced with.
+func (g *GenX) rewriteCallExpr(node *xast.Node) *xast.Node {
+	n := node.Node().(*ast.CallExpr)
+	name, targs := g.intrinsicCall(n)
+	if name == "" {
+		return node
+	}
+
+	fail := func(format string, args ...interface{}) *xast.Node {
+		g.fail(fmt.Errorf("intrinsics.%s: %s (%s)", name, fmt.Sprintf(format, args...), g.fset.Position(n.Pos())))
+		return node
+	}
+
+	var x ast.Expr
+	switch name {
+	case "Less", "Equal":
+		if len(n.Args) != 2 {
+			return fail("expected 2 arguments, got %d", len(n.Args))
+		}
+		a, b := n.Args[0], n.Args[1]
+		src, t := g.concreteType(g.info.TypeOf(a))
+		if m := g.methodOf(t, name); m != nil || t == nil {
+			// types we can't resolve are assumed to have the method.
+			sel := ast.NewIdent(name)
+			if m != nil {
+				g.info.Uses[sel] = m
+			}
+			x = &ast.CallExpr{Fun: &ast.SelectorExpr{X: operand(a), Sel: sel}, Args: []ast.Expr{b}}
+			break
+		}
+
+		if name == "Less" {
+			if b, ok := t.Underlying().(*types.Basic); !ok || b.Info()&types.IsOrdered == 0 {
+				return fail("%s isn't ordered and doesn't have a Less method", src)
+			}
+			x = &ast.BinaryExpr{X: binaryOperand(a), Op: token.LSS, Y: binaryOperand(b)}
+			break
+		}
+
+		if s, ok := t.Underlying().(*types.Slice); ok && types.Identical(s.Elem(), types.Typ[types.Byte]) {
+			x = &ast.CallExpr{Fun: g.pkgFunc("bytes", "Equal"), Args: []ast.Expr{a, b}}
+			break
+		}
+		if !types.Comparable(t) {
+			return fail("%s isn't comparable and doesn't have an Equal method", src)
+		}
+		x = &ast.BinaryExpr{X: binaryOperand(a), Op: token.EQL, Y: binaryOperand(b)}
+
+	case "Zero":
+		if len(targs) != 1 || len(n.Args) != 0 {
+			return fail("expected a single type argument")
+		}
+		typ := targs[0]
+		src, t := g.concreteType(g.info.TypeOf(typ))
+		if tt := g.info.TypeOf(typ); src != types.TypeString(tt, types.RelativeTo(g.pkg)) {
+			// the placeholder's name would get rewritten again.
+			id := ast.NewIdent(src)
+			g.visited[id] = true
+			typ = id
+		}
+		x = g.zeroValue(typ, t, false)
+
+	case "IsBuiltin":
+		if len(n.Args) != 1 {
+			return fail("expected 1 argument, got %d", len(n.Args))
+		}
+		lit, ok := n.Args[0].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return fail("the placeholder has to be a string literal")
+		}
+		ph, _ := strconv.Unquote(lit.Value)
+		x = g.universeIdent(strconv.FormatBool(g.isBuiltinPlaceholder(ph)))
+
+	default:
+		return node
+	}
+
+	switch node.Parent().Node().(type) {
+	case *ast.UnaryExpr, *ast.BinaryExpr, *ast.SelectorExpr, *ast.StarExpr:
+		if _, ok := x.(*ast.BinaryExpr); ok {
+			x = &ast.ParenExpr{X: x}
+		}
+	}
+	return node.SetNode(x)
+}
+
+// isBuiltinPlaceholder reports whether the placeholder name ends up as a builtin type.
+func (g *GenX) isBuiltinPlaceholder(name string) bool {
+	if src := g.rewriters["type:"+name]; src != "" && src != "-" {
+		return isBuiltinType(src)
+	}
+	if g.pkg == nil {
+		return false
+	}
+	// aliases (ex: type T = int)
+	if tn, ok := g.pkg.Scope().Lookup(name).(*types.TypeName); ok {
+		_, ok = types.Unalias(tn.Type()).(*types.Basic)
+		return ok
+	}
+	return false
+}
+
+// methodOf returns the method name of t, or nil if it doesn't have one.
+func (g *GenX) methodOf(t types.Type, name string) types.Object {
+	if t == nil {
+		return nil
+	}
+	obj, _, _ := types.LookupFieldOrMethod(t, true, g.pkg, name)
+	if fn, ok := obj.(*types.Func); ok {
+		return fn
+	}
+	return nil
+}
+
+// universeIdent returns the builtin name (ex: true or new), marked as such so it doesn't get renamed.
+func (g *GenX) universeIdent(name string) *ast.Ident {
+	id := ast.NewIdent(name)
+	g.info.Uses[id] = types.Universe.Lookup(name)
+	return id
+}
+
+// pkgFunc returns pkg.name, marked as coming from another package so it doesn't get renamed,
+// goimports adds the import.
+func (g *GenX) pkgFunc(pkg, name string) ast.Expr {
+	p := types.NewPackage(pkg, pkg)
+	x, sel := ast.NewIdent(pkg), ast.NewIdent(name)
+	g.info.Uses[x] = types.NewPkgName(token.NoPos, g.pkg, pkg, p)
+	g.info.Uses[sel] = types.NewFunc(token.NoPos, p, name, nil)
+	return &ast.SelectorExpr{X: x, Sel: sel}
+}
+
+// operand wraps x in parens if it can't be used as the receiver of a method call as is.
+func operand(x ast.Expr) ast.Expr {
+	switch x.(type) {
+	case *ast.Ident, *ast.SelectorExpr, *ast.CallExpr, *ast.IndexExpr, *ast.IndexListExpr, *ast.ParenExpr, *ast.BasicLit, *ast.CompositeLit:
+		return x
+	}
+	return &ast.ParenExpr{X: x}
+}
+
+// binaryOperand wraps x in parens if it's a binary expression itself.
+func binaryOperand(x ast.Expr) ast.Expr {
+	if _, ok := x.(*ast.BinaryExpr); ok {
+		return &ast.ParenExpr{X: x}
+	}
+	return x
+}
+
+// isBuiltinType reports whether the type sel is a builtin one (ex: int or []byte, but not []int),
+// interface{} doesn't count.
+func isBuiltinType(sel string) bool {
+	x, err := parser.ParseExpr(sel)
+	if err != nil {
+		return false
+	}
+	switch s := types.ExprString(x); s {
+	case "interface{}", "Interface":
+		return false
+	default:
+		return builtins[s] != ""
+	}
+}
@@ -0,0 +1,49 @@
+// Package intrinsics has helpers for the parts of a template that depend on what the placeholders are replaced with,
+// genx expands every call inline for the concrete types, ex: `intrinsics.Less(a, b)` becomes `a < b` for ints and
+// `a.Less(b)` for everything else.
+// The functions work at runtime as well, so templates can still be compiled and tested on their own.
+package intrinsics
+
+import "reflect"
+
+// Less reports whether a sorts before b, using < for ordered types and a.Less(b) for everything else.
+func Less[T any](a, b T) bool {
+	if l, ok := any(a).(interface{ Less(T) bool }); ok {
+		return l.Less(b)
+	}
+
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch va.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return va.Int() < vb.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return va.Uint() < vb.Uint()
+	case reflect.Float32, reflect.Float64:
+		return va.Float() < vb.Float()
+	case reflect.String:
+		return va.String() < vb.String()
+	}
+	panic("intrinsics.Less: " + va.Type().String() + " isn't ordered and doesn't have a Less method")
+}
+
+// Equal reports whether a and b are equal, using a.Equal(b) if T has that method and == otherwise.
+func Equal[T any](a, b T) bool {
+	if eq, ok := any(a).(interface{ Equal(T) bool }); ok {
+		return eq.Equal(b)
+	}
+	if bs, ok := any(a).([]byte); ok {
+		return string(bs) == string(any(b).([]byte))
+	}
+	return any(a) == any(b)
+}
+
+// Zero returns the zero value of T.
+func Zero[T any]() (z T) {
+	return
+}
+
+// IsBuiltin reports whether the placeholder name is replaced with a builtin type (ex: int, string or []byte).
+// It can only be known while generating, so it always returns false at runtime.
+func IsBuiltin(name string) bool {
+	return false
+}
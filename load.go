@@ -0,0 +1,252 @@
+package genx
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/build"
+	"go/types"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// srcPkg is a package to parse, along with the packages it imports when they could be loaded.
+type srcPkg struct {
+	fsys    fs.FS // where the files are, the OS's file system if nil
+	dir     string
+	path    string // import path, empty if it's unknown
+	files   []string
+	xtests  []string // external tests (package foo_test)
+	imports map[string]*packages.Package
+}
+
+// readFile reads the file fn of the package.
+func (sp *srcPkg) readFile(fn string) ([]byte, error) {
+	if sp.fsys != nil {
+		return fs.ReadFile(sp.fsys, fn)
+	}
+	return os.ReadFile(fn)
+}
+
+// loadPkg finds the package in path with go/packages, so modules, replace directives, workspaces and vendor
+// directories are handled like the go command does.
+// path can be an import path, optionally with a version from the module cache (ex: github.com/OneOfOne/cmap@v1.2.0/hashers),
+// or a directory, directories that aren't part of a module are read directly, like the ones in g.FS.
+func (g *GenX) loadPkg(path string, includeTests bool) (*srcPkg, error) {
+	if g.FS != nil {
+		return g.importDir(path, includeTests)
+	}
+
+	cfg := &packages.Config{
+		Mode:       packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps | packages.NeedTypes,
+		BuildFlags: append([]string{"-tags=" + strings.Join(g.BuildTags, ",")}, g.GoFlags...),
+		Tests:      includeTests,
+		Env:        g.goEnv(),
+	}
+
+	pattern := path
+	if idx := strings.LastIndex(path, "@"); idx > 0 {
+		pkg, version := path[:idx], path[idx+1:]
+		// ex: github.com/OneOfOne/cmap@v1.2.0/hashers
+		if i := strings.Index(version, "/"); i != -1 {
+			pkg, version = pkg+version[i:], version[:i]
+		}
+		dir, err := g.moduleDir(pkg, version)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Dir, pattern = dir, "."
+	} else if isDir(path) {
+		cfg.Dir, pattern = path, "."
+	}
+
+	pkgs, err := packages.Load(cfg, pattern)
+	if err != nil {
+		if isDir(path) {
+			return g.importDir(path, includeTests)
+		}
+		return nil, err
+	}
+
+	var (
+		pkg   *packages.Package
+		xtest *packages.Package
+	)
+	for _, p := range pkgs {
+		if strings.HasSuffix(p.Name, "_test") {
+			xtest = p
+			continue
+		}
+		if strings.HasSuffix(p.ID, ".test") {
+			continue
+		}
+		// the test variant (ex: foo [foo.test]) has the tests of the package, the other one doesn't.
+		if pkg == nil || (includeTests && strings.HasSuffix(p.ID, ".test]")) {
+			pkg = p
+		}
+	}
+
+	if pkg == nil || len(pkg.GoFiles) == 0 {
+		if isDir(path) {
+			return g.importDir(path, includeTests)
+		}
+		if pkg != nil && len(pkg.Errors) > 0 {
+			return nil, fmt.Errorf("%s: %v", path, pkg.Errors[0].Msg)
+		}
+		return nil, fmt.Errorf("%s: no Go files", path)
+	}
+
+	sp := &srcPkg{dir: filepath.Dir(pkg.GoFiles[0]), path: pkg.PkgPath, files: pkg.GoFiles, imports: pkg.Imports}
+	if xtest != nil {
+		sp.xtests = xtest.GoFiles
+		sp.imports = make(map[string]*packages.Package, len(pkg.Imports)+len(xtest.Imports))
+		for _, imps := range []map[string]*packages.Package{xtest.Imports, pkg.Imports} {
+			for p, ip := range imps {
+				sp.imports[p] = ip
+			}
+		}
+	}
+	return sp, nil
+}
+
+// importDir reads the package in dir with go/build, from g.FS if it's set.
+func (g *GenX) importDir(dir string, includeTests bool) (*srcPkg, error) {
+	ctx := build.Default
+	ctx.BuildTags = append(ctx.BuildTags, g.BuildTags...)
+	join := filepath.Join
+	if fsys := g.FS; fsys != nil {
+		join = path.Join
+		ctx.GOROOT, ctx.GOPATH = "", ""
+		ctx.JoinPath, ctx.IsAbsPath = path.Join, path.IsAbs
+		ctx.IsDir = func(p string) bool {
+			fi, err := fs.Stat(fsys, p)
+			return err == nil && fi.IsDir()
+		}
+		ctx.HasSubdir = func(root, dir string) (string, bool) { return "", false }
+		ctx.ReadDir = func(dir string) ([]fs.FileInfo, error) {
+			entries, err := fs.ReadDir(fsys, dir)
+			out := make([]fs.FileInfo, 0, len(entries))
+			for _, e := range entries {
+				if fi, err := e.Info(); err == nil {
+					out = append(out, fi)
+				}
+			}
+			return out, err
+		}
+		ctx.OpenFile = func(p string) (io.ReadCloser, error) { return fsys.Open(p) }
+	}
+
+	pkg, err := ctx.ImportDir(dir, build.IgnoreVendor)
+	if err != nil {
+		return nil, err
+	}
+
+	names := append([]string{}, pkg.GoFiles...)
+	if includeTests {
+		names = append(names, pkg.TestGoFiles...)
+	}
+
+	sp := &srcPkg{fsys: g.FS, dir: pkg.Dir}
+	if pkg.ImportPath != "." {
+		sp.path = pkg.ImportPath
+	}
+	for _, name := range names {
+		sp.files = append(sp.files, join(pkg.Dir, name))
+	}
+	if includeTests {
+		for _, name := range pkg.XTestGoFiles {
+			sp.xtests = append(sp.xtests, join(pkg.Dir, name))
+		}
+	}
+	return sp, nil
+}
+
+// PkgDir returns the directory of the package in path, which is resolved the same way ParsePkg does.
+func (g *GenX) PkgDir(path string) (string, error) {
+	pkg, err := g.loadPkg(path, false)
+	if err != nil {
+		return "", err
+	}
+	return pkg.dir, nil
+}
+
+// moduleDir returns the directory of the package path at version in the module cache,
+// the module gets downloaded if it isn't there and GOPROXY allows it.
+func (g *GenX) moduleDir(path, version string) (string, error) {
+	// the module is the longest prefix of path that is one, they're all looked up at once.
+	parts := strings.Split(path, "/")
+	args := []string{"mod", "download", "-json"}
+	for i := len(parts); i > 0; i-- {
+		args = append(args, strings.Join(parts[:i], "/")+"@"+version)
+	}
+	cmd := exec.Command("go", args...)
+	cmd.Env = g.goEnv()
+	out, cmdErr := cmd.Output()
+
+	var (
+		dirs     = map[string]string{}
+		firstErr error
+	)
+	for dec := json.NewDecoder(bytes.NewReader(out)); ; {
+		var info struct{ Path, Dir, Error string }
+		if dec.Decode(&info) != nil {
+			break
+		}
+		if info.Dir != "" {
+			dirs[info.Path] = info.Dir
+		} else if info.Error != "" && firstErr == nil {
+			firstErr = errors.New(info.Error)
+		}
+	}
+
+	for i := len(parts); i > 0; i-- {
+		if dir := dirs[strings.Join(parts[:i], "/")]; dir != "" {
+			return filepath.Join(append([]string{dir}, parts[i:]...)...), nil
+		}
+	}
+	switch {
+	case firstErr != nil:
+	case cmdErr != nil:
+		firstErr = fmt.Errorf("%s@%s: %v", path, version, cmdErr)
+	default:
+		firstErr = fmt.Errorf("%s@%s: not found", path, version)
+	}
+	return "", firstErr
+}
+
+// goEnv returns the environment of the go commands, nil if it's the process' one.
+// The go command ignores the flags of GOFLAGS that don't apply to it, so GoFlags go there.
+func (g *GenX) goEnv() []string {
+	if len(g.Env) == 0 && len(g.GoFlags) == 0 {
+		return nil
+	}
+	env := append(os.Environ(), g.Env...)
+	if len(g.GoFlags) > 0 {
+		env = append(env, "GOFLAGS="+strings.TrimSpace(os.Getenv("GOFLAGS")+" "+strings.Join(g.GoFlags, " ")))
+	}
+	return env
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// pkgImporter imports the packages go/packages already loaded, anything else (ex: the imports the type arguments
+// need) goes through the source importer.
+type pkgImporter map[string]*packages.Package
+
+func (imp pkgImporter) Import(path string) (*types.Package, error) {
+	if p := imp[path]; p != nil && p.Types != nil && !p.IllTyped {
+		return p.Types, nil
+	}
+	return sharedImporter{}.Import(path)
+}
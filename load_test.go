@@ -0,0 +1,59 @@
+package genx_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OneOfOne/genx"
+	"github.com/OneOfOne/genx/seeds"
+)
+
+func TestParsePkgPaths(t *testing.T) {
+	// a directory that isn't part of any module.
+	dir := t.TempDir()
+	src, err := os.ReadFile("./seeds/set/set.go")
+	fatalIf(t, err)
+	fatalIf(t, os.WriteFile(filepath.Join(dir, "set.go"), src, 0o644))
+
+	var exp []byte
+	for _, path := range []string{"./seeds/set", "github.com/OneOfOne/genx/seeds/set", dir} {
+		pkg, err := genx.New("", map[string]string{"type:T": "string"}).ParsePkg(path, false)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		merged, err := pkg.MergeAll(false)
+		fatalIf(t, err)
+		if exp == nil {
+			exp = merged.Src
+		} else if !bytes.Equal(exp, merged.Src) {
+			t.Errorf("%s: expected:\n%s\ngot:\n%s", path, exp, merged.Src)
+		}
+	}
+
+	if _, err := genx.New("", nil).ParsePkg("github.com/OneOfOne/genx/seeds/nope", false); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestParsePkgFS(t *testing.T) {
+	for _, typ := range []string{"string", "*pkg.OtherType"} {
+		rewriters := map[string]string{"type:T": typ}
+		pkg, err := genx.New("", rewriters).ParsePkg("./seeds/sort", false)
+		fatalIf(t, err)
+		exp, err := pkg.MergeAll(false)
+		fatalIf(t, err)
+
+		g := genx.New("", rewriters)
+		g.FS = seeds.FS
+		if pkg, err = g.ParsePkg("sort", false); err != nil {
+			t.Fatalf("%s: %v", typ, err)
+		}
+		merged, err := pkg.MergeAll(false)
+		fatalIf(t, err)
+		if !bytes.Equal(exp.Src, merged.Src) {
+			t.Errorf("%s: expected:\n%s\ngot:\n%s", typ, exp.Src, merged.Src)
+		}
+	}
+}
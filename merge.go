@@ -0,0 +1,229 @@
+package genx
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/tools/imports"
+)
+
+// MergeAll merges the files of the package into a single file named all_gen.go, or all_gen_test.go with the tests.
+func (p ParsedPkg) MergeAll(tests bool) (ParsedFile, error) {
+	name := "all_gen.go"
+	if tests {
+		name = "all_gen_test.go"
+	}
+	return p.MergeAllAs(name, tests)
+}
+
+// MergeAllAs merges the files of the package, or only its tests, into a single file named name.
+// The imports of every file are merged, the ones that use different names for the same package or the same name for
+// different packages get renamed, everything else (ex: license headers, comments and the order of the declarations)
+// is kept as is.
+func (p ParsedPkg) MergeAllAs(name string, tests bool) (ParsedFile, error) {
+	pf := ParsedFile{Name: name}
+
+	fset := token.NewFileSet()
+	var (
+		files []*ast.File
+		srcs  [][]byte
+		lines = map[string]LineMap{}
+	)
+	for _, f := range p {
+		if strings.HasSuffix(f.Name, "_test.go") != tests {
+			continue
+		}
+		file, err := parser.ParseFile(fset, f.Name, f.Src, parser.ParseComments)
+		if err != nil {
+			return pf, err
+		}
+		files, srcs = append(files, file), append(srcs, f.Src)
+		lines[f.Name] = f.Lines
+	}
+	if len(files) == 0 {
+		return pf, fmt.Errorf("nothing to merge")
+	}
+
+	m := newImportMerger(files)
+	var body bytes.Buffer
+	for i, f := range files {
+		body.Write(m.rewrite(fset, f, srcs[i], i == 0))
+		body.WriteByte('\n')
+	}
+
+	var buf bytes.Buffer
+	// the first file's header (ex: license) goes before the package clause.
+	buf.Write(srcs[0][:fset.Position(files[0].Package).Offset])
+	fmt.Fprintf(&buf, "package %s\n\n", files[0].Name.Name)
+	if len(m.specs) > 0 {
+		buf.WriteString("import (\n")
+		for _, s := range m.specs {
+			fmt.Fprintf(&buf, "\t%s %s\n", s.name, strconv.Quote(s.path))
+		}
+		buf.WriteString(")\n\n")
+	}
+	buf.Write(body.Bytes())
+
+	out, err := imports.Process(pf.Name, buf.Bytes(), &imports.Options{
+		AllErrors: true,
+		Comments:  true,
+		TabIndent: true,
+		TabWidth:  4,
+	})
+	if err != nil {
+		pf.Src = buf.Bytes()
+		return pf, err
+	}
+	pf.Src = out
+	pf.Lines = throughLines(mapLines(fset, files, pf.Name, out, false), lines)
+	return pf, nil
+}
+
+type importSpec struct {
+	name string // empty if it's the package's name
+	path string
+}
+
+// importMerger merges the imports of files, the first file to import a package decides its name.
+type importMerger struct {
+	specs   []importSpec
+	names   map[string]string               // path => the name it's used by
+	paths   map[string]string               // name => path
+	renames map[*ast.File]map[string]string // file => its names that changed
+}
+
+func newImportMerger(files []*ast.File) *importMerger {
+	m := &importMerger{
+		names:   map[string]string{},
+		paths:   map[string]string{},
+		renames: map[*ast.File]map[string]string{},
+	}
+
+	// new names can't collide with the package's declarations either.
+	taken := map[string]bool{}
+	for _, f := range files {
+		for name := range f.Scope.Objects {
+			taken[name] = true
+		}
+	}
+
+	seen := map[importSpec]bool{}
+	for _, f := range files {
+		m.renames[f] = map[string]string{}
+		for _, is := range f.Imports {
+			p, _ := strconv.Unquote(is.Path.Value)
+			s := importSpec{path: p}
+			if is.Name != nil {
+				s.name = is.Name.Name
+			}
+
+			if s.name == "_" || s.name == "." {
+				if !seen[s] {
+					seen[s] = true
+					m.specs = append(m.specs, s)
+				}
+				continue
+			}
+
+			local := s.name
+			if local == "" {
+				local = assumedPkgName(p)
+			}
+
+			if name, ok := m.names[p]; ok {
+				if name != local {
+					m.renames[f][local] = name
+				}
+				continue
+			}
+
+			name := local
+			for i := 2; m.paths[name] != "" || taken[name]; i++ {
+				name = local + strconv.Itoa(i)
+			}
+			if name != local {
+				s.name = name
+				m.renames[f][local] = name
+			}
+			m.names[p], m.paths[name] = name, p
+			m.specs = append(m.specs, s)
+		}
+	}
+	return m
+}
+
+// rewrite returns the source of f without its package clause and imports, and with the packages it uses renamed,
+// the header (ex: license and package docs) of the first file is left out since it goes before the merged file's package clause.
+func (m *importMerger) rewrite(fset *token.FileSet, f *ast.File, src []byte, first bool) []byte {
+	type edit struct {
+		start, end int
+		repl       string
+	}
+	off := func(pos token.Pos) int { return fset.Position(pos).Offset }
+
+	var edits []edit
+	if first {
+		edits = append(edits, edit{0, off(f.Name.End()), ""})
+	} else {
+		edits = append(edits, edit{off(f.Package), off(f.Name.End()), ""})
+	}
+
+	for _, d := range f.Decls {
+		if gd, ok := d.(*ast.GenDecl); ok && gd.Tok == token.IMPORT {
+			start := gd.Pos()
+			if gd.Doc != nil {
+				start = gd.Doc.Pos()
+			}
+			edits = append(edits, edit{off(start), off(gd.End()), ""})
+		}
+	}
+
+	if renames := m.renames[f]; len(renames) > 0 {
+		ast.Inspect(f, func(n ast.Node) bool {
+			sel, ok := n.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			// unresolved identifiers are the ones declared at the file level, aka the imports.
+			if x, ok := sel.X.(*ast.Ident); ok && x.Obj == nil && renames[x.Name] != "" {
+				edits = append(edits, edit{off(x.Pos()), off(x.End()), renames[x.Name]})
+			}
+			return true
+		})
+	}
+
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	var (
+		out  = make([]byte, 0, len(src))
+		last int
+	)
+	for _, e := range edits {
+		out = append(append(out, src[last:e.start]...), e.repl...)
+		last = e.end
+	}
+	return append(out, src[last:]...)
+}
+
+// assumedPkgName returns the name a package is assumed to have from its import path,
+// ex: gopkg.in/yaml.v2 => yaml, github.com/x/go-pkg/v2 => pkg.
+func assumedPkgName(p string) string {
+	base := path.Base(p)
+	if strings.HasPrefix(base, "v") {
+		if _, err := strconv.Atoi(base[1:]); err == nil && path.Dir(p) != "." {
+			base = path.Base(path.Dir(p))
+		}
+	}
+	base = strings.TrimPrefix(base, "go-")
+	if i := strings.IndexFunc(base, func(r rune) bool { return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) }); i >= 0 {
+		base = base[:i]
+	}
+	return base
+}
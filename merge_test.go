@@ -0,0 +1,67 @@
+package genx_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/OneOfOne/genx"
+)
+
+func TestMergeAll(t *testing.T) {
+	pkg := genx.ParsedPkg{
+		{Name: "a.go", Src: []byte(`// Copyright A
+
+// Package x does things.
+package x
+
+import (
+	"fmt"
+	str "strings"
+)
+
+// A is from a.go
+func A() string { return str.ToUpper(fmt.Sprint(1)) }
+
+// import "os"
+const raw = ` + "`" + `
+import "os"
+` + "`" + `
+`)},
+		{Name: "b.go", Src: []byte(`// Copyright B
+
+package x
+
+import (
+	"strings"
+
+	"example.com/other/fmt"
+)
+
+// B is from b.go
+func B() string { return strings.ToLower(fmt.Name) }
+`)},
+		{Name: "a_test.go", Src: []byte("package x\n")},
+	}
+
+	merged, err := pkg.MergeAllAs("x_gen.go", false)
+	fatalIf(t, err)
+
+	if merged.Name != "x_gen.go" {
+		t.Errorf("unexpected name: %s", merged.Name)
+	}
+
+	for _, re := range []string{
+		`(?s)^// Copyright A\n\n// Package x does things.\npackage x\n`,
+		`\tfmt2 "example.com/other/fmt"\n`,
+		`\tstr "strings"\n`,
+		`(?s)// A is from a\.go.*// import "os"\nconst raw = ` + "`" + `\nimport "os"\n` + "`" + `.*// Copyright B\n.*// B is from b\.go`,
+		`return str\.ToLower\(fmt2\.Name\)`,
+	} {
+		if !regexp.MustCompile(re).Match(merged.Src) {
+			t.Errorf("%s didn't match:\n%s", re, merged.Src)
+		}
+	}
+	if n := len(regexp.MustCompile(`(?m)^package `).FindAll(merged.Src, -1)); n != 1 {
+		t.Errorf("expected 1 package clause, got %d:\n%s", n, merged.Src)
+	}
+}
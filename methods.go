@@ -0,0 +1,122 @@
+package genx
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+)
+
+// prepareMethodRenames maps the methods targeted by receiver qualified func rewriters (ex: func:TSet.Keys=SortedKeys)
+// to their new names, along with the methods of the template's interfaces their types implement and the same methods
+// of every other type implementing those interfaces, so they all keep doing so.
+func (g *GenX) prepareMethodRenames() {
+	g.methodRenames = map[types.Object]string{}
+	if g.pkg == nil {
+		return
+	}
+
+	var renamed []*types.Func
+	for _, obj := range g.info.Defs {
+		fn, ok := obj.(*types.Func)
+		if !ok {
+			continue
+		}
+		named := recvNamed(fn)
+		if named == nil || types.IsInterface(named) {
+			continue
+		}
+		key := "func:" + named.Obj().Name() + "." + fn.Name()
+		if nn, ok := g.rewriters[key]; ok && nn != "-" {
+			g.use(key)
+			g.methodRenames[fn] = nn
+			renamed = append(renamed, fn)
+		}
+	}
+
+	var ifaces, named []*types.TypeName
+	scope := g.pkg.Scope()
+	for _, name := range scope.Names() {
+		tn, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok || tn.IsAlias() {
+			continue
+		}
+		if iface, ok := tn.Type().Underlying().(*types.Interface); !ok {
+			named = append(named, tn)
+		} else if !iface.Empty() {
+			ifaces = append(ifaces, tn)
+		}
+	}
+
+	rename := func(m *types.Func, nn string) bool {
+		if old, ok := g.methodRenames[m]; ok {
+			if old != nn {
+				g.fail(fmt.Errorf("%s: conflicting renames %s and %s", m.FullName(), old, nn))
+			}
+			return false
+		}
+		g.methodRenames[m] = nn
+		return true
+	}
+
+	// the renamed methods of the implementers get added to renamed as they're found.
+	for i := 0; i < len(renamed); i++ {
+		fn, nn := renamed[i], g.methodRenames[renamed[i]]
+		for _, tn := range ifaces {
+			iface := tn.Type().Underlying().(*types.Interface)
+			if !implements(recvNamed(fn), iface) {
+				continue
+			}
+			obj, _, _ := types.LookupFieldOrMethod(iface, false, g.pkg, fn.Name())
+			m, ok := obj.(*types.Func)
+			if !ok || m.Pkg() != g.pkg {
+				continue
+			}
+			rename(m, nn)
+
+			for _, t := range named {
+				if !implements(t.Type(), iface) {
+					continue
+				}
+				obj, _, _ := types.LookupFieldOrMethod(t.Type(), true, g.pkg, fn.Name())
+				m, ok := obj.(*types.Func)
+				if !ok || m.Pkg() != g.pkg {
+					g.fail(fmt.Errorf("func:%s.%s=%s: %s implements %s with a method that can't be renamed",
+						recvNamed(fn).Obj().Name(), fn.Name(), nn, t.Name(), tn.Name()))
+					continue
+				}
+				if rename(m, nn) {
+					renamed = append(renamed, m)
+				}
+			}
+		}
+	}
+}
+
+// implements returns whether t or a pointer to it implements iface.
+func implements(t types.Type, iface *types.Interface) bool {
+	return types.Implements(t, iface) || types.Implements(types.NewPointer(t), iface)
+}
+
+// recvNamed returns the named type of fn's receiver, or nil if it isn't a method.
+func recvNamed(fn *types.Func) *types.Named {
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return nil
+	}
+	t := recv.Type()
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	n, _ := t.(*types.Named)
+	return n
+}
+
+// methodRename returns the new name of the method n declares or refers to if it's targeted by a receiver qualified rewriter.
+func (g *GenX) methodRename(n *ast.Ident) (string, bool) {
+	fn, ok := g.objectOf(n).(*types.Func)
+	if !ok {
+		return "", false
+	}
+	nn, ok := g.methodRenames[fn.Origin()]
+	return nn, ok
+}
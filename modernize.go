@@ -0,0 +1,654 @@
+package genx
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"regexp"
+	"sort"
+
+	"github.com/OneOfOne/xast"
+	"golang.org/x/tools/imports"
+)
+
+const (
+	constraintAny = iota
+	constraintComparable
+	constraintOrdered
+	constraintNumber
+)
+
+var constraintNames = [...]string{"any", "comparable", "cmp.Ordered", "Number"}
+
+const numberConstraint = `
+// Number is a constraint that permits any integer or floating-point type.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+`
+
+// Modernize converts a placeholder based template package (`type KT interface{}`, genny's `generic.Type`)
+// into generic Go code, every type and func that depends on a placeholder gets a type parameter for it,
+// constrained to `comparable`, `cmp.Ordered` or `Number` depending on how the placeholder is used.
+// Names built around the placeholders are cleaned up as well, ex: `TSet` => `Set[T comparable]`.
+// If no placeholders are specified, every empty interface and genny type declared in the package is used.
+func (g *GenX) Modernize(path string, placeholders ...string) (out ParsedPkg, err error) {
+	fset, names, files, err := g.parseDir(path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	g.checkTypes(fset, files)
+
+	m := &modernizer{
+		g:           g,
+		isPh:        map[types.Object]bool{},
+		constraints: map[types.Object]int{},
+		direct:      map[types.Object]map[types.Object]bool{},
+		deps:        map[types.Object]map[types.Object]bool{},
+		inferable:   map[types.Object]map[types.Object]bool{},
+		params:      map[types.Object][]*types.TypeName{},
+		renames:     map[types.Object]string{},
+		anyIdents:   map[*ast.Ident]bool{},
+		nils:        g.nilTypes(files),
+	}
+
+	if err = m.findPlaceholders(files, placeholders); err != nil {
+		return nil, err
+	}
+
+	m.collectDeps(files)
+	m.collectConstraints(files)
+	if err = m.checkVars(files); err != nil {
+		return nil, err
+	}
+	m.renameDecls()
+
+	out = make(ParsedPkg, 0, len(files))
+	for i, f := range files {
+		if g.pkgName != "" {
+			f.Name.Name = g.pkgName
+		}
+
+		xast.Walk(f, m.rewrite)
+		m.cleanUp(f)
+
+		var buf bytes.Buffer
+		if err = printer.Fprint(&buf, fset, f); err != nil {
+			return
+		}
+
+		if i == 0 && m.needsNumber {
+			buf.WriteString(numberConstraint)
+		}
+
+		pf := ParsedFile{Name: names[i]}
+		if pf.Src, err = imports.Process(pf.Name, buf.Bytes(), &imports.Options{
+			AllErrors: true,
+			Comments:  true,
+			TabIndent: true,
+			TabWidth:  4,
+		}); err != nil {
+			pf.Src = buf.Bytes()
+			return append(out, pf), err
+		}
+		out = append(out, pf)
+	}
+
+	return
+}
+
+type modernizer struct {
+	g *GenX
+
+	phs         []*types.TypeName
+	isPh        map[types.Object]bool
+	constraints map[types.Object]int
+
+	// direct are the placeholders each declaration uses, deps are the other declarations it uses.
+	direct map[types.Object]map[types.Object]bool
+	deps   map[types.Object]map[types.Object]bool
+	// inferable are the placeholders that appear in the params of a func, ex: f(KT) vs f() KT.
+	inferable map[types.Object]map[types.Object]bool
+
+	params    map[types.Object][]*types.TypeName
+	renames   map[types.Object]string
+	anyIdents map[*ast.Ident]bool
+	nils      map[*ast.Ident]types.Type
+
+	needsNumber bool
+}
+
+func (m *modernizer) findPlaceholders(files []*ast.File, names []string) error {
+	want := map[string]bool{}
+	for _, n := range names {
+		want[n] = true
+	}
+	explicit := len(want) > 0
+
+	for _, f := range files {
+		for _, d := range f.Decls {
+			gd, ok := d.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, s := range gd.Specs {
+				ts := s.(*ast.TypeSpec)
+				c, ok := placeholderConstraint(ts)
+				if explicit {
+					ok = want[ts.Name.Name]
+					delete(want, ts.Name.Name)
+				}
+				if !ok || ts.TypeParams != nil {
+					continue
+				}
+				obj := m.g.info.Defs[ts.Name].(*types.TypeName)
+				m.phs = append(m.phs, obj)
+				m.isPh[obj] = true
+				m.constraints[obj] = c
+			}
+		}
+	}
+
+	for n := range want {
+		return fmt.Errorf("placeholder %s isn't declared in package %s", n, m.g.pkg.Name())
+	}
+
+	if len(m.phs) == 0 {
+		return fmt.Errorf("package %s doesn't have any placeholder types", m.g.pkg.Name())
+	}
+
+	return nil
+}
+
+// placeholderConstraint reports whether ts is a placeholder and the minimum constraint it implies.
+func placeholderConstraint(ts *ast.TypeSpec) (int, bool) {
+	switch t := ts.Type.(type) {
+	case *ast.InterfaceType:
+		return constraintAny, t.Methods == nil || len(t.Methods.List) == 0
+	case *ast.SelectorExpr:
+		if x, ok := t.X.(*ast.Ident); !ok || x.Name != "generic" {
+			break
+		}
+		switch t.Sel.Name {
+		case "Type":
+			return constraintAny, true
+		case "Number":
+			return constraintNumber, true
+		}
+	}
+	return 0, false
+}
+
+// declObject returns the object a top level declaration belongs to, methods belong to their receiver's type.
+func (m *modernizer) declObject(fd *ast.FuncDecl) types.Object {
+	if fd.Recv != nil && len(fd.Recv.List) == 1 {
+		return m.g.objectOf(getIdent(fd.Recv.List[0].Type))
+	}
+	return m.g.info.Defs[fd.Name]
+}
+
+func (m *modernizer) collectDeps(files []*ast.File) {
+	uses := func(owner types.Object, n ast.Node) {
+		if owner == nil || n == nil {
+			return
+		}
+		if m.direct[owner] == nil {
+			m.direct[owner], m.deps[owner] = map[types.Object]bool{}, map[types.Object]bool{}
+		}
+		ast.Inspect(n, func(n ast.Node) bool {
+			id, ok := n.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			switch obj := m.g.info.Uses[id].(type) {
+			case *types.TypeName:
+				if m.isPh[obj] {
+					m.direct[owner][obj] = true
+				} else if obj.Pkg() == m.g.pkg && obj.Parent() == m.g.pkg.Scope() && obj != owner {
+					m.deps[owner][obj] = true
+				}
+			case *types.Func:
+				if obj.Pkg() == m.g.pkg && obj.Parent() == m.g.pkg.Scope() && obj != owner {
+					m.deps[owner][obj] = true
+				}
+			}
+			return true
+		})
+	}
+
+	for _, f := range files {
+		for _, d := range f.Decls {
+			switch d := d.(type) {
+			case *ast.GenDecl:
+				if d.Tok != token.TYPE {
+					continue
+				}
+				for _, s := range d.Specs {
+					ts := s.(*ast.TypeSpec)
+					if obj := m.g.info.Defs[ts.Name]; !m.isPh[obj] {
+						uses(obj, ts.Type)
+					}
+				}
+			case *ast.FuncDecl:
+				obj := m.declObject(d)
+				uses(obj, d)
+				if d.Recv == nil && obj != nil {
+					m.inferable[obj] = map[types.Object]bool{}
+				}
+			}
+		}
+	}
+
+	// a declaration needs every placeholder its dependencies need.
+	for changed := true; changed; {
+		changed = false
+		for owner, deps := range m.deps {
+			for dep := range deps {
+				for ph := range m.direct[dep] {
+					if !m.direct[owner][ph] {
+						m.direct[owner][ph], changed = true, true
+					}
+				}
+			}
+		}
+	}
+
+	// what a func can infer from its params.
+	for fn := range m.inferable {
+		fd := m.funcDecl(files, fn)
+		ast.Inspect(fd.Type.Params, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				obj := m.g.info.Uses[id]
+				if m.isPh[obj] {
+					m.inferable[fn][obj] = true
+				}
+				for ph := range m.direct[obj] {
+					m.inferable[fn][ph] = true
+				}
+			}
+			return true
+		})
+	}
+
+	for owner, phs := range m.direct {
+		for _, ph := range m.phs {
+			if phs[ph] {
+				m.params[owner] = append(m.params[owner], ph)
+			}
+		}
+	}
+}
+
+func (m *modernizer) funcDecl(files []*ast.File, fn types.Object) *ast.FuncDecl {
+	for _, f := range files {
+		for _, d := range f.Decls {
+			if fd, ok := d.(*ast.FuncDecl); ok && fd.Recv == nil && m.g.info.Defs[fd.Name] == fn {
+				return fd
+			}
+		}
+	}
+	return nil
+}
+
+func (m *modernizer) collectConstraints(files []*ast.File) {
+	for _, f := range files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.MapType:
+				m.require(m.g.info.TypeOf(n.Key), constraintComparable, map[types.Type]bool{})
+			case *ast.SwitchStmt:
+				if n.Tag != nil {
+					m.require(m.g.info.TypeOf(n.Tag), constraintComparable, map[types.Type]bool{})
+				}
+			case *ast.BinaryExpr:
+				var c int
+				switch n.Op {
+				case token.EQL, token.NEQ:
+					c = constraintComparable
+				case token.LSS, token.LEQ, token.GTR, token.GEQ, token.ADD:
+					c = constraintOrdered
+				case token.SUB, token.MUL, token.QUO, token.REM:
+					c = constraintNumber
+				default:
+					return true
+				}
+				m.require(m.g.info.TypeOf(n.X), c, map[types.Type]bool{})
+				m.require(m.g.info.TypeOf(n.Y), c, map[types.Type]bool{})
+			}
+			return true
+		})
+	}
+
+	// the template's //genx:constraint directives, as long as they're one of ours.
+	for _, c := range findConstraints(files) {
+		dc, ok := directiveConstraints[c.expr]
+		if !ok {
+			continue
+		}
+		for _, ph := range m.phs {
+			for _, name := range c.names {
+				if ph.Name() == name && m.constraints[ph] < dc {
+					m.constraints[ph] = dc
+				}
+			}
+		}
+	}
+
+	for _, ph := range m.phs {
+		if m.constraints[ph] == constraintNumber {
+			m.needsNumber = true
+		}
+	}
+}
+
+var directiveConstraints = map[string]int{
+	"any":         constraintAny,
+	"comparable":  constraintComparable,
+	"ordered":     constraintOrdered,
+	"cmp.Ordered": constraintOrdered,
+}
+
+// require raises the constraint of every placeholder t is made of to at least c.
+func (m *modernizer) require(t types.Type, c int, seen map[types.Type]bool) {
+	if t == nil || seen[t] {
+		return
+	}
+	seen[t] = true
+
+	switch tt := t.(type) {
+	case *types.Named:
+		if obj := tt.Obj(); m.isPh[obj] {
+			if m.constraints[obj] < c {
+				m.constraints[obj] = c
+			}
+			return
+		}
+		if c == constraintComparable && tt.Obj().Pkg() == m.g.pkg {
+			m.require(tt.Underlying(), c, seen)
+		}
+	case *types.Struct:
+		for i := 0; i < tt.NumFields(); i++ {
+			m.require(tt.Field(i).Type(), c, seen)
+		}
+	case *types.Array:
+		m.require(tt.Elem(), c, seen)
+	}
+}
+
+// checkVars makes sure package level vars and consts don't depend on placeholders,
+// placeholders used only in their values are replaced with `any`, ex: `unsafe.Pointer(new(VT))`.
+func (m *modernizer) checkVars(files []*ast.File) (err error) {
+	for _, f := range files {
+		for _, d := range f.Decls {
+			gd, ok := d.(*ast.GenDecl)
+			if !ok || (gd.Tok != token.VAR && gd.Tok != token.CONST) {
+				continue
+			}
+			for _, s := range gd.Specs {
+				vs := s.(*ast.ValueSpec)
+				for _, n := range vs.Names {
+					if obj := m.g.info.Defs[n]; obj != nil && m.dependsOnPlaceholder(obj.Type()) {
+						return fmt.Errorf("%s: package level %s depends on a placeholder", m.g.pkg.Name(), n.Name)
+					}
+				}
+				for _, v := range vs.Values {
+					ast.Inspect(v, func(n ast.Node) bool {
+						id, ok := n.(*ast.Ident)
+						if !ok {
+							return true
+						}
+						obj := m.g.info.Uses[id]
+						if m.isPh[obj] {
+							m.anyIdents[id] = true
+						} else if len(m.params[obj]) > 0 && err == nil {
+							err = fmt.Errorf("%s: package level %s depends on the generic %s", m.g.pkg.Name(), vs.Names[0].Name, id.Name)
+						}
+						return true
+					})
+				}
+			}
+		}
+	}
+	return
+}
+
+func (m *modernizer) dependsOnPlaceholder(t types.Type) (found bool) {
+	seen := map[types.Type]bool{}
+	var walk func(t types.Type)
+	walk = func(t types.Type) {
+		if found || t == nil || seen[t] {
+			return
+		}
+		seen[t] = true
+		switch tt := t.(type) {
+		case *types.Named:
+			found = m.isPh[tt.Obj()] || len(m.params[tt.Obj()]) > 0
+		case *types.Pointer:
+			walk(tt.Elem())
+		case *types.Slice:
+			walk(tt.Elem())
+		case *types.Array:
+			walk(tt.Elem())
+		case *types.Chan:
+			walk(tt.Elem())
+		case *types.Map:
+			walk(tt.Key())
+			walk(tt.Elem())
+		case *types.Signature:
+			for i := 0; i < tt.Params().Len(); i++ {
+				walk(tt.Params().At(i).Type())
+			}
+			for i := 0; i < tt.Results().Len(); i++ {
+				walk(tt.Results().At(i).Type())
+			}
+		case *types.Struct:
+			for i := 0; i < tt.NumFields(); i++ {
+				walk(tt.Field(i).Type())
+			}
+		}
+	}
+	walk(t)
+	return
+}
+
+// renameDecls strips the placeholders from the names of the package's declarations and methods,
+// ex: TSet => Set, NewAtomicT => NewAtomic, entryVT => entry.
+// A declaration keeps its name if the new one would collide with something else or change its visibility.
+func (m *modernizer) renameDecls() {
+	phNames := make([]string, 0, len(m.phs))
+	for _, ph := range m.phs {
+		phNames = append(phNames, ph.Name())
+	}
+	// longest first so KT isn't eaten by T.
+	sort.Slice(phNames, func(i, j int) bool { return len(phNames[i]) > len(phNames[j]) })
+
+	rename := func(obj types.Object, taken func(string) bool) {
+		if m.isPh[obj] {
+			return
+		}
+		name := obj.Name()
+		nn := stripPlaceholders(name, phNames)
+		if nn == name || nn == "" || nn == "_" || ast.IsExported(nn) != ast.IsExported(name) || taken(nn) {
+			return
+		}
+		m.renames[obj] = nn
+	}
+
+	scope := m.g.pkg.Scope()
+	used := map[string]bool{}
+	for _, n := range scope.Names() {
+		if !m.isPh[scope.Lookup(n)] {
+			used[n] = true
+		}
+	}
+
+	for _, n := range scope.Names() {
+		rename(scope.Lookup(n), func(nn string) bool {
+			if used[nn] || token.Lookup(nn).IsKeyword() || types.Universe.Lookup(nn) != nil {
+				return true
+			}
+			used[nn] = true
+			return false
+		})
+	}
+
+	for _, n := range scope.Names() {
+		tn, ok := scope.Lookup(n).(*types.TypeName)
+		if !ok {
+			continue
+		}
+		named, ok := tn.Type().(*types.Named)
+		if !ok {
+			continue
+		}
+		for i := 0; i < named.NumMethods(); i++ {
+			fn := named.Method(i)
+			rename(fn, func(nn string) bool {
+				obj, _, _ := types.LookupFieldOrMethod(named, true, m.g.pkg, nn)
+				return obj != nil
+			})
+		}
+	}
+}
+
+// stripPlaceholders removes every placeholder that is a whole word of name, the plural ones are kept (ex: SortTs)
+// and the glued ones only lose their escape since they aren't (ex: KT__er => KTer).
+func stripPlaceholders(name string, phs []string) string {
+	glued, strip := make(map[string]string, len(phs)), make(map[string]string, len(phs))
+	for _, ph := range phs {
+		glued["type:"+ph], strip["type:"+ph] = ph, ""
+	}
+	r := geireplacer(strip, nil)
+	r.noPlurals = true
+	return r.Replace(geireplacer(glued, nil).Replace(name))
+}
+
+func (m *modernizer) typeParams(obj types.Object) *ast.FieldList {
+	phs := m.params[obj]
+	if len(phs) == 0 {
+		return nil
+	}
+	fl := &ast.FieldList{}
+	for _, ph := range phs {
+		c := m.constraints[ph]
+		fl.List = append(fl.List, &ast.Field{
+			Names: []*ast.Ident{ast.NewIdent(ph.Name())},
+			Type:  ast.NewIdent(constraintNames[c]),
+		})
+	}
+	return fl
+}
+
+func (m *modernizer) typeArgs(obj types.Object) []ast.Expr {
+	phs := m.params[obj]
+	out := make([]ast.Expr, 0, len(phs))
+	for _, ph := range phs {
+		out = append(out, ast.NewIdent(ph.Name()))
+	}
+	return out
+}
+
+func (m *modernizer) rewrite(node *xast.Node) *xast.Node {
+	info := m.g.info
+	switch n := node.Node().(type) {
+	case *ast.TypeSpec:
+		obj := info.Defs[n.Name]
+		if m.isPh[obj] {
+			return node.Delete()
+		}
+		n.TypeParams = m.typeParams(obj)
+
+	case *ast.FuncDecl:
+		if n.Recv == nil {
+			n.Type.TypeParams = m.typeParams(info.Defs[n.Name])
+		}
+
+	case *ast.Ident:
+		if m.anyIdents[n] {
+			return node.SetNode(ast.NewIdent("any"))
+		}
+
+		if t := m.nils[n]; t != nil {
+			if named, ok := t.(*types.Named); ok && m.isPh[named.Obj()] {
+				return node.SetNode(&ast.StarExpr{X: &ast.CallExpr{
+					Fun:  ast.NewIdent("new"),
+					Args: []ast.Expr{ast.NewIdent(named.Obj().Name())},
+				}})
+			}
+		}
+
+		obj := m.g.objectOf(n)
+		if nn, ok := m.renames[obj]; ok {
+			n.Name = nn
+		}
+
+		use := info.Uses[n]
+		if use == nil || len(m.params[use]) == 0 || m.isPh[use] {
+			break
+		}
+
+		switch use := use.(type) {
+		case *types.TypeName:
+		case *types.Func:
+			if call, ok := node.Parent().Node().(*ast.CallExpr); ok && call.Fun == n && m.canInfer(use) {
+				return node
+			}
+		default:
+			return node
+		}
+
+		x := ast.NewIdent(n.Name)
+		x.NamePos = n.NamePos
+		if args := m.typeArgs(use); len(args) > 1 {
+			return node.SetNode(&ast.IndexListExpr{X: x, Indices: args})
+		}
+		return node.SetNode(&ast.IndexExpr{X: x, Index: ast.NewIdent(m.params[use][0].Name())})
+	}
+	return node
+}
+
+func (m *modernizer) canInfer(fn types.Object) bool {
+	for _, ph := range m.params[fn] {
+		if !m.inferable[fn][ph] {
+			return false
+		}
+	}
+	return true
+}
+
+// cleanUp removes the empty declarations left behind by the placeholders and renames them in comments.
+func (m *modernizer) cleanUp(f *ast.File) {
+	decls := f.Decls[:0]
+	for _, d := range f.Decls {
+		if gd, ok := d.(*ast.GenDecl); ok && len(gd.Specs) == 0 {
+			continue
+		}
+		decls = append(decls, d)
+	}
+	f.Decls = decls
+
+	var filters []func(string) string
+	for obj, nn := range m.renames {
+		filters = append(filters, regexpReplacer(`\b`+regexp.QuoteMeta(obj.Name())+`\b`, nn))
+	}
+
+	for _, cg := range f.Comments {
+		list := cg.List[:0]
+		for _, c := range cg.List {
+			if c.Text = nukeGenxComments(c.Text); c.Text == "" {
+				continue
+			}
+			for _, fn := range filters {
+				c.Text = fn(c.Text)
+			}
+			list = append(list, c)
+		}
+		cg.List = list
+	}
+}
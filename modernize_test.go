@@ -0,0 +1,31 @@
+package genx_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/OneOfOne/genx"
+)
+
+func TestModernizeSeeds(t *testing.T) {
+	seeds := map[string]*regexp.Regexp{
+		"set":         regexp.MustCompile(`type Set\[T comparable\] map\[T\]struct\{\}`),
+		"atomicValue": regexp.MustCompile(`func \(a \*Atomic\[T\]\) Swap\(newV T\) \(oldV T\)`),
+		"atomicMap":   regexp.MustCompile(`type Map\[KT comparable, VT any\] struct`),
+		"sort":        regexp.MustCompile(`func SortTs\[T any\]\(s \[\]T, less func\(i, j int\) bool\)`),
+	}
+	for seed, mustMatch := range seeds {
+		t.Run(seed, func(t *testing.T) {
+			pkg, err := genx.New("", nil).Modernize("./seeds/" + seed)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, pkg)
+			}
+
+			if merged, err := pkg.MergeAll(false); err != nil || !mustMatch.Match(merged.Src) {
+				t.Errorf("%v: %s didn't match\n%s", err, mustMatch, merged.Src)
+			}
+
+			typeCheck(t, seed, pkg)
+		})
+	}
+}
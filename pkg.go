@@ -6,8 +6,6 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-
-	"golang.org/x/tools/imports"
 )
 
 var header = []byte(`// This file was automatically generated by genx.
@@ -18,6 +16,10 @@ var header = []byte(`// This file was automatically generated by genx.
 type ParsedFile struct {
 	Name string
 	Src  []byte
+
+	// Lines maps the lines of Src (not the ones of the written file, which starts with a header) to the template
+	// positions they came from, lines that aren't from the template (ex: imports) aren't in it.
+	Lines LineMap
 }
 
 func (f ParsedFile) WriteFile(path string) error {
@@ -26,6 +28,18 @@ func (f ParsedFile) WriteFile(path string) error {
 
 type ParsedPkg []ParsedFile
 
+// withLineDirectives adds //line directives to the files of p, the paths of the templates are made relative to dir,
+// p is returned as is if dir is empty.
+func (p ParsedPkg) withLineDirectives(dir string) ParsedPkg {
+	if dir == "" {
+		return p
+	}
+	for i, pf := range p {
+		p[i] = pf.withLineDirectives(dir)
+	}
+	return p
+}
+
 func (p ParsedPkg) WritePkg(dir string) error {
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
@@ -38,53 +52,8 @@ func (p ParsedPkg) WritePkg(dir string) error {
 	return nil
 }
 
-func (p ParsedPkg) MergeAll(tests bool) (ParsedFile, error) {
-	// TODO: look into doing this with ast
-	// var cleanSrc = regexp.MustCompile(`// nolint$`)
-	var totalLen int
-	for _, f := range p {
-		isTest := strings.HasSuffix(f.Name, "_test.go")
-		if (isTest && !tests) || (!isTest && tests) {
-			continue
-		}
-		totalLen += len(f.Src)
-	}
-
-	pf := ParsedFile{
-		Name: "all_gen.go",
-		Src:  make([]byte, 0, totalLen),
-	}
-
-	for i, f := range p {
-		isTest := strings.HasSuffix(f.Name, "_test.go")
-		if (isTest && !tests) || (!isTest && tests) {
-			continue
-		}
-		// f.Src = cleanSrc.ReplaceAll(f.Src, []byte("$1"))
-		if i > 0 {
-			f.Src = removePkgAndImports.ReplaceAll(f.Src, nil)
-		}
-		pf.Src = append(pf.Src, f.Src...)
-
-	}
-
-	// log.Printf("%s", out)
-	out, err := imports.Process(pf.Name, pf.Src, &imports.Options{
-		AllErrors: true,
-		Comments:  true,
-		TabIndent: true,
-		TabWidth:  4,
-	})
-
-	if err == nil {
-		pf.Src = out
-	}
-
-	return pf, err
-}
-
 func (p ParsedPkg) WriteAllMerged(fname string, tests bool) error {
-	pf, err := p.MergeAll(tests)
+	pf, err := p.MergeAllAs(filepath.Base(fname), tests)
 	if err != nil {
 		log.Printf("partial output:\n%s", pf.Src)
 		return err
@@ -0,0 +1,225 @@
+package genx
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// LineMap maps the lines of a generated file to the template positions they came from.
+type LineMap map[int]token.Position
+
+func (m LineMap) String() string {
+	lines := make([]int, 0, len(m))
+	for l := range m {
+		lines = append(lines, l)
+	}
+	sort.Ints(lines)
+
+	var sb strings.Builder
+	for _, l := range lines {
+		fmt.Fprintf(&sb, "%d => %s\n", l, m[l])
+	}
+	return sb.String()
+}
+
+// Lookup returns the template position of line, the lines that aren't in m (ex: blank lines) get the position of the
+// closest line before them that is, moved down by as many lines, like //line directives do.
+func (m LineMap) Lookup(line int) (token.Position, bool) {
+	if pos, ok := m[line]; ok {
+		return pos, true
+	}
+	prev := 0
+	for l := range m {
+		if l < line && l > prev {
+			prev = l
+		}
+	}
+	if prev == 0 {
+		return token.Position{}, false
+	}
+	pos := m[prev]
+	pos.Line, pos.Column, pos.Offset = pos.Line+line-prev, 0, 0
+	return pos, true
+}
+
+// Generated returns the lines that came from line of the template file filename, in order.
+func (m LineMap) Generated(filename string, line int) (out []int) {
+	for l, pos := range m {
+		if pos.Filename == filename && pos.Line == line {
+			out = append(out, l)
+		}
+	}
+	sort.Ints(out)
+	return
+}
+
+// mapLines maps the lines of src, which was printed from the files in fset, to the positions of the nodes they came
+// from, by walking both in order and pairing the nodes of the same kind, adjusted is whether the positions of the files
+// follow their //line directives.
+// Nodes that are only in src (ex: the imports goimports adds) are skipped, so are the ones that are only in the files
+// (ex: branches that got pruned).
+func mapLines(fset *token.FileSet, files []*ast.File, name string, src []byte, adjusted bool) LineMap {
+	ofset := token.NewFileSet()
+	out, err := parser.ParseFile(ofset, name, src, parser.ParseComments)
+	if err != nil {
+		return nil
+	}
+
+	var from []ast.Node
+	for _, f := range files {
+		from = append(from, declNodes(f)...)
+	}
+
+	var pairs [][2]ast.Node // generated, template
+	next := 0
+	for _, n := range declNodes(out) {
+		for i := next; i < len(from); i++ {
+			if sameNode(n, from[i]) {
+				pairs, next = append(pairs, [2]ast.Node{n, from[i]}), i+1
+				break
+			}
+		}
+	}
+
+	lines := LineMap{}
+	// the first node of a line is the outermost one, the lines nothing starts on (ex: closing braces) get the end of
+	// the node that ends there.
+	for _, end := range []bool{false, true} {
+		for _, p := range pairs {
+			gpos, tpos := p[0].Pos(), p[1].Pos()
+			if !tpos.IsValid() {
+				continue
+			}
+			if end {
+				// the declarations the rewriters emptied don't have an end.
+				if gd, ok := p[1].(*ast.GenDecl); ok && len(gd.Specs) == 0 && !gd.Rparen.IsValid() {
+					continue
+				}
+				gpos, tpos = p[0].End()-1, p[1].End()-1
+			}
+			if line := ofset.PositionFor(gpos, false).Line; lines[line].Line == 0 {
+				lines[line] = fset.PositionFor(tpos, adjusted)
+			}
+		}
+	}
+	return lines
+}
+
+// throughLines maps lines through the lines of the files they point to, ex: instance => processed file => template.
+func throughLines(lines LineMap, files map[string]LineMap) LineMap {
+	out := make(LineMap, len(lines))
+	for line, pos := range lines {
+		if p, ok := files[pos.Filename][pos.Line]; ok {
+			out[line] = p
+		}
+	}
+	return out
+}
+
+// declNodes returns the nodes of the declarations of f in order, the imports are left out since goimports rewrites them.
+func declNodes(f *ast.File) (out []ast.Node) {
+	for _, d := range f.Decls {
+		if gd, ok := d.(*ast.GenDecl); ok && gd.Tok == token.IMPORT {
+			continue
+		}
+		ast.Inspect(d, func(n ast.Node) bool {
+			if n != nil {
+				out = append(out, n)
+			}
+			return true
+		})
+	}
+	return
+}
+
+func sameNode(a, b ast.Node) bool {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+	if lit, ok := a.(*ast.BasicLit); ok {
+		return lit.Value == b.(*ast.BasicLit).Value
+	}
+	return true
+}
+
+// withLineDirectives returns f with a //line directive before every line whose template position doesn't follow the
+// one of the line before it, the paths of the templates are made relative to dir.
+func (f ParsedFile) withLineDirectives(dir string) ParsedFile {
+	if len(f.Lines) == 0 {
+		return f
+	}
+
+	var (
+		buf   bytes.Buffer
+		cur   token.Position // the position the directives give the current line
+		n     int
+		lines = make(LineMap, len(f.Lines))
+	)
+	inside := continuedLines(f.Src)
+	for i, l := range bytes.SplitAfter(f.Src, []byte("\n")) {
+		pos, ok := f.Lines[i+1]
+		if cur.Line > 0 {
+			cur.Line++
+		}
+		if ok && !inside[i+1] && (pos.Filename != cur.Filename || pos.Line != cur.Line) {
+			fmt.Fprintf(&buf, "//line %s:%d\n", relPath(dir, pos.Filename), pos.Line)
+			cur, n = pos, n+1
+		}
+		if n++; ok {
+			lines[n] = pos
+		}
+		buf.Write(l)
+	}
+
+	f.Src, f.Lines = buf.Bytes(), lines
+	return f
+}
+
+// continuedLines returns the lines of src that start inside a token (ex: a raw string or a /* comment */),
+// where a directive would change the token.
+func continuedLines(src []byte) map[int]bool {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+
+	var s scanner.Scanner
+	s.Init(file, src, nil, scanner.ScanComments)
+
+	out := map[int]bool{}
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok != token.STRING && tok != token.COMMENT {
+			continue
+		}
+		start := file.PositionFor(pos, false).Line
+		for l := start + 1; l <= start+strings.Count(lit, "\n"); l++ {
+			out[l] = true
+		}
+	}
+	return out
+}
+
+// relPath returns fn relative to dir if it can, paths that aren't absolute (ex: the ones in GenX.FS) are left alone.
+func relPath(dir, fn string) string {
+	if !filepath.IsAbs(fn) {
+		return fn
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return fn
+	}
+	if rel, err := filepath.Rel(abs, fn); err == nil {
+		return rel
+	}
+	return fn
+}
@@ -2,6 +2,8 @@ package genx
 
 import (
 	"go/ast"
+	"go/types"
+	"regexp"
 	"strings"
 
 	"github.com/OneOfOne/xast"
@@ -9,6 +11,10 @@ import (
 
 func (g *GenX) rewriteField(node *xast.Node) *xast.Node {
 	n := node.Node().(*ast.Field)
+	if len(n.Names) == 0 && g.isDead(embeddedIdent(n.Type)) {
+		return node.Delete()
+	}
+
 	nn := g.rewrite(xast.NewNode(node, n.Type))
 	if nn.Canceled() {
 		return node.Delete()
@@ -21,14 +27,26 @@ func (g *GenX) rewriteField(node *xast.Node) *xast.Node {
 
 	names := n.Names[:0]
 	for _, n := range n.Names {
+		if g.isDead(n) {
+			continue
+		}
+		// interface methods
+		if nn, ok := g.methodRename(n); ok {
+			n.Name = nn
+			names = append(names, n)
+			continue
+		}
 		nn, ok := g.rewriters["field:"+n.Name]
-		if nn == "-" {
+		if ok = ok && g.isField(n); ok {
+			g.use("field:" + n.Name)
+		}
+		if ok && nn == "-" {
 			continue
 		}
 		if ok {
 			n.Name = nn
-		} else {
-			n.Name = g.irepl.Replace(n.Name)
+		} else if g.isPkgDecl(n) {
+			n.Name = g.renameIdent(n.Name)
 		}
 		names = append(names, n)
 
@@ -40,18 +58,56 @@ func (g *GenX) rewriteField(node *xast.Node) *xast.Node {
 	return node
 }
 
+// rewriteGenDecl removes the types, vars and consts that depend on something that was removed.
+func (g *GenX) rewriteGenDecl(node *xast.Node) *xast.Node {
+	n := node.Node().(*ast.GenDecl)
+	if len(g.dead) == 0 {
+		return node
+	}
+
+	specs := n.Specs[:0]
+	for _, s := range n.Specs {
+		switch s := s.(type) {
+		case *ast.TypeSpec:
+			if g.isDead(s.Name) {
+				continue
+			}
+		case *ast.ValueSpec:
+			dead := false
+			for _, n := range s.Names {
+				dead = dead || g.isDead(n)
+			}
+			if dead {
+				continue
+			}
+		}
+		specs = append(specs, s)
+	}
+
+	if n.Specs = specs; len(specs) == 0 {
+		return node.Delete()
+	}
+	return node
+}
+
 func (g *GenX) rewriteTypeSpec(node *xast.Node) *xast.Node {
 	n := node.Node().(*ast.TypeSpec)
 	if t := getIdent(n.Name); t != nil {
+		var args []string
+		if n.TypeParams, args = g.stripTypeParams(n.TypeParams); len(args) > 0 {
+			t.Name = g.instanceName(t.Name, args)
+		}
+
 		nn := g.rewrite(xast.NewNode(node, n.Type))
 		if nn.Canceled() {
 			return node.Delete()
 		}
 		n.Type = nn.Node().(ast.Expr)
 		tn, ok := g.rewriters["type:"+t.Name]
-		if !ok {
+		if !ok || !g.isPkgType(t) {
 			return node
 		}
+		g.use("type:" + t.Name)
 		if tn == "-" {
 			return node.Delete()
 		}
@@ -64,14 +120,50 @@ func (g *GenX) rewriteTypeSpec(node *xast.Node) *xast.Node {
 
 func (g *GenX) rewriteIdent(node *xast.Node) *xast.Node {
 	n := node.Node().(*ast.Ident)
-	if t, ok := g.rewriters["type:"+n.Name]; ok {
+	if _, ok := g.nils[n]; ok {
+		return g.rewriteNil(node)
+	}
+
+	if t, ok := g.typeArg(n); ok {
+		return g.setType(node, t)
+	}
+
+	if t, ok := g.rewriters["type:"+n.Name]; ok && g.isPkgType(n) {
+		g.use("type:" + n.Name)
 		if t == "-" {
 			return node.Delete()
 		}
-		n.Name = t
-	} else {
-		n.Name = g.irepl.Replace(n.Name)
+		return g.setType(node, t)
+	}
+
+	if !g.isPkgDecl(n) {
+		return node
+	}
+
+	if nn, ok := g.methodRename(n); ok {
+		n.Name = nn
+		return node
+	}
+
+	// only renames uses, the declaration is handled by rewriteFuncDecl.
+	if fn, ok := g.objectOf(n).(*types.Func); ok && fn.Name() == n.Name {
+		if nn := g.rewriters["func:"+n.Name]; nn != "" && nn != "-" {
+			g.use("func:" + n.Name)
+			n.Name = nn
+			return node
+		}
 	}
+
+	// both the declaration and the uses, removals are handled by findDeadDecls.
+	if key := valueKey(g.objectOf(n)); key != "" && n.Name == key[strings.Index(key, ":")+1:] {
+		if nn := g.rewriters[key]; nn != "" && nn != "-" {
+			g.use(key)
+			n.Name = nn
+			return node
+		}
+	}
+
+	n.Name = g.renameIdent(n.Name)
 	return node
 }
 
@@ -110,7 +202,8 @@ func (g *GenX) rewriteStarExpr(node *xast.Node) *xast.Node {
 
 func (g *GenX) rewriteChanType(node *xast.Node) *xast.Node {
 	n := node.Node().(*ast.ChanType)
-	if x := getIdent(n.Value); x != nil && g.rewriters["type:"+x.Name] == "-" {
+	if x := getIdent(n.Value); x != nil && g.rewriters["type:"+x.Name] == "-" && g.isPkgType(x) {
+		g.use("type:" + x.Name)
 		return deleteWithParent(node)
 	}
 	return node
@@ -118,6 +211,7 @@ func (g *GenX) rewriteChanType(node *xast.Node) *xast.Node {
 
 func (g *GenX) rewriteFuncType(node *xast.Node) *xast.Node {
 	n := node.Node().(*ast.FuncType)
+	n.TypeParams, _ = g.stripTypeParams(n.TypeParams)
 	if n.Params != nil {
 		for _, p := range n.Params.List {
 			nn := g.rewrite(xast.NewNode(node, p.Type))
@@ -129,16 +223,12 @@ func (g *GenX) rewriteFuncType(node *xast.Node) *xast.Node {
 	}
 
 	if n.Results != nil {
-		g.curReturnTypes = g.curReturnTypes[:0]
 		for _, p := range n.Results.List {
 			nn := g.rewrite(xast.NewNode(node, p.Type))
 			if nn.Canceled() {
 				return node.Delete()
 			}
 			p.Type = nn.Node().(ast.Expr)
-			if rt := getIdent(p.Type); rt != nil {
-				g.curReturnTypes = append(g.curReturnTypes, rt.Name)
-			}
 		}
 	}
 
@@ -167,13 +257,27 @@ func (g *GenX) rewriteComment(node *xast.Node) *xast.Node {
 			return node.Delete()
 		}
 	}
+	// placeholders become their type, and the identifiers that contain them are renamed like in the code.
+	n.Text = commentWordRE.ReplaceAllStringFunc(n.Text, func(w string) string {
+		if v, ok := g.rewriters["type:"+w]; ok && v != "-" {
+			return v
+		}
+		return g.crepl.Replace(w)
+	})
 	return node
 }
 
+var commentWordRE = regexp.MustCompile(`[\p{L}_][\p{L}\p{N}_]*`)
+
 func (g *GenX) rewriteKeyValueExpr(node *xast.Node) *xast.Node {
 	n := node.Node().(*ast.KeyValueExpr)
 	if t := getIdent(n.Key); t != nil {
-		if g.rewriters["type:"+t.Name] == "-" || g.rewriters["field:"+t.Name] == "-" {
+		if g.rewriters["type:"+t.Name] == "-" && g.isPkgType(t) {
+			g.use("type:" + t.Name)
+			return node.Delete()
+		}
+		if g.rewriters["field:"+t.Name] == "-" && g.isField(t) {
+			g.use("field:" + t.Name)
 			return node.Delete()
 		}
 	}
@@ -187,24 +291,11 @@ func (g *GenX) rewriteKeyValueExpr(node *xast.Node) *xast.Node {
 	return node
 }
 
-func (g *GenX) rewriteReturnStmt(node *xast.Node) *xast.Node {
-	n := node.Node().(*ast.ReturnStmt)
-	for i, r := range n.Results {
-		if rt := getIdent(r); rt != nil && rt.Name == "nil" {
-			crt := cleanUpName.ReplaceAllString(g.curReturnTypes[i], "")
-			if _, ok := g.zeroTypes[crt]; ok {
-				g.zeroTypes[crt] = true
-				rt.Name = "zero_" + cleanUpName.ReplaceAllString(crt, "")
-			}
-		}
-	}
-	return node
-}
-
 func (g *GenX) rewriteInterfaceType(node *xast.Node) *xast.Node {
 	n := node.Node().(*ast.InterfaceType)
 	if n.Methods != nil && len(n.Methods.List) == 0 {
 		if nt, ok := g.rewriters["type:interface{}"]; ok {
+			g.use("type:interface{}")
 			if nt == "-" {
 				return deleteWithParent(node)
 			}
@@ -223,21 +314,35 @@ func (g *GenX) rewriteSelectorExpr(node *xast.Node) *xast.Node {
 	if x == nil || n.Sel == nil {
 		return node
 	}
-	if nv := g.rewriters["selector:."+n.Sel.Name]; nv != "" {
+
+	// method calls, values and expressions, x is handled by rewriteIdent.
+	if nn, ok := g.methodRename(n.Sel); ok {
+		n.Sel.Name = nn
+		return node
+	}
+	if nv := g.rewriters["selector:."+n.Sel.Name]; nv != "" && g.isField(n.Sel) {
+		g.use("selector:." + n.Sel.Name)
 		n.Sel.Name = nv
 		return node
 	}
 
 	nv := g.rewriters["selector:"+x.Name+"."+n.Sel.Name]
 	if nv == "" {
-		if x.Name == g.pkgName {
+		if x.Name == g.pkgName && g.isPkgName(x) {
 			x.Name = n.Sel.Name
 			return node.SetNode(x)
 		}
-		x.Name, n.Sel.Name = g.irepl.Replace(x.Name), g.irepl.Replace(n.Sel.Name)
+		if g.isPkgDecl(x) {
+			x.Name = g.renameIdent(x.Name)
+		}
+		if g.isPkgDecl(n.Sel) {
+			n.Sel.Name = g.renameIdent(n.Sel.Name)
+		}
 		return node
 	}
 
+	g.use("selector:" + x.Name + "." + n.Sel.Name)
+
 	if nv == "-" {
 		return node.Delete()
 	}
@@ -254,8 +359,17 @@ func (g *GenX) rewriteSelectorExpr(node *xast.Node) *xast.Node {
 
 func (g *GenX) rewriteFuncDecl(node *xast.Node) *xast.Node {
 	n := node.Node().(*ast.FuncDecl)
-	if t := getIdent(n.Name); t != nil {
+	if g.isDead(n.Name) {
+		return node.Delete()
+	}
+
+	if nn, ok := g.methodRename(n.Name); ok {
+		n.Name.Name = nn
+	} else if t := getIdent(n.Name); t != nil {
 		nn := g.rewriters["func:"+t.Name]
+		if nn != "" {
+			g.use("func:" + t.Name)
+		}
 		if nn == "-" {
 			return node.Delete()
 		} else if nn != "" {
@@ -264,16 +378,19 @@ func (g *GenX) rewriteFuncDecl(node *xast.Node) *xast.Node {
 	}
 
 	if recv := n.Recv; recv != nil && len(recv.List) == 1 {
+		g.rewriteRecvTypeParams(recv.List[0])
 		t := getIdent(recv.List[0].Type)
 		nn, ok := g.rewriters["type:"+t.Name]
-
-		if nn == "-" {
+		if ok = ok && g.isPkgType(t); ok {
+			g.use("type:" + t.Name)
+		}
+		if ok && nn == "-" {
 			return node.Delete()
 		}
 		if ok {
 			t.Name = nn
 		} else {
-			t.Name = g.irepl.Replace(t.Name)
+			t.Name = g.renameIdent(t.Name)
 		}
 	}
 
@@ -290,7 +407,7 @@ func (g *GenX) rewriteFuncDecl(node *xast.Node) *xast.Node {
 	return node
 }
 
-var nukeGenxComments = regexpReplacer(`// \+build.*|//go:generate.*`, "")
+var nukeGenxComments = regexpReplacer(`// \+build.*|//go:generate.*|//\s*genx:.*`, "")
 
 func (g *GenX) rewriteFile(node *xast.Node) *xast.Node {
 	n := node.Node().(*ast.File)
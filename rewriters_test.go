@@ -1,8 +1,16 @@
 package genx_test
 
 import (
+	"bytes"
+	"errors"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
 	"io/ioutil"
 	"log"
+	"reflect"
 	"regexp"
 	"testing"
 
@@ -12,12 +20,15 @@ import (
 func init() {
 	log.SetFlags(log.Lshortfile)
 }
+
+type rewriteCase struct {
+	Name        string
+	Input       map[string]string
+	FailIfMatch *regexp.Regexp
+}
+
 func TestAllTypes(t *testing.T) {
-	testCases := []struct {
-		Name        string
-		Input       map[string]string
-		FailIfMatch *regexp.Regexp
-	}{
+	testCases := []rewriteCase{
 		{
 			"Delete:Type:interface{}",
 			map[string]string{
@@ -61,11 +72,977 @@ func TestAllTypes(t *testing.T) {
 			map[string]string{
 				"func:DoStuff": "-",
 			},
-			// regexp.MustCompile(`DoStuff\(|\sTwo\(`), // TODO: fix #5
-			regexp.MustCompile(`DoStuff\(`),
+			regexp.MustCompile(`DoStuff\(|\sTwo\(`),
+		},
+	}
+	runRewriteCases(t, "./all_types.go", testCases)
+
+	// identifiers that share their names with the placeholders but aren't them.
+	shadowCases := []rewriteCase{
+		{
+			"Shadow:Type:KT=string",
+			map[string]string{"type:KT": "string"},
+			regexp.MustCompile(`string\s+int|string := 1|StringInt|SetString\(v KT\)`),
+		},
+		{
+			"Shadow:Type:T=int",
+			map[string]string{"type:T": "int"},
+			regexp.MustCompile(`type int struct|int{VT|Int\s+string|f\.Int`),
+		},
+		{
+			"Shadow:Type:VT=uint64",
+			map[string]string{"type:VT": "uint64"},
+			regexp.MustCompile(`[uU]int64 int|[uU]int64: KT`),
+		},
+		{
+			"Shadow:Type:U=int",
+			map[string]string{"type:U": "int"},
+			regexp.MustCompile(`\bint\.ToUpper|Int\.ToUpper`),
+		},
+		{
+			"Shadow:Func:Renamed",
+			map[string]string{"func:Renamed": "Other"},
+			regexp.MustCompile(`Renamed\(|Other int|return Other`),
+		},
+	}
+	runRewriteCases(t, "./shadow_types.go", shadowCases)
+
+	genericCases := []rewriteCase{
+		{
+			"Generic:K=string,V=int",
+			map[string]string{
+				"type:K": "string",
+				"type:V": "int",
+			},
+			regexp.MustCompile(`\b(K|V|KK|VV)\b|comparable|V any|\[(string|int),|Map\[|entry\[|Stringey`),
+		},
+		{
+			"Generic:V=uint64",
+			map[string]string{
+				"type:V": "uint64",
+			},
+			regexp.MustCompile(`\bV\b|\[K, V\]|entry\[|Transform\[uint64|MapUint64\[K\]\[`),
+		},
+	}
+	runRewriteCases(t, "./generic_types.go", genericCases)
+}
+
+func TestCollisions(t *testing.T) {
+	const src = `package x
+
+type KT interface{}
+
+type KTVT struct {
+	Key   KT
+	Value int
+}
+
+type StringVT struct{}
+
+func (m *KTVT) Get() KT { return m.Key }
+func (m *KTVT) Set(k KT) { m.Key = k }
+`
+	testCases := []struct {
+		Name  string
+		Input map[string]string
+		Err   *regexp.Regexp
+	}{
+		{
+			"Type",
+			map[string]string{"type:KT": "string"},
+			regexp.MustCompile(`KTVT \(src\.go:5:6\) and StringVT \(src\.go:10:6\) are both renamed to StringVT`),
+		},
+		{
+			"Field",
+			map[string]string{"field:Key": "Value"},
+			regexp.MustCompile(`KTVT\.Key \(src\.go:6:2\) and KTVT\.Value \(src\.go:7:2\) are both renamed to KTVT\.Value`),
+		},
+		{
+			"Method",
+			map[string]string{"func:Set": "Get"},
+			regexp.MustCompile(`KTVT\.Get \(src\.go:12:16\) and KTVT\.Set \(src\.go:13:16\) are both renamed to KTVT\.Get`),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			pf, err := genx.New("", tc.Input).Parse("src.go", src)
+			if err == nil {
+				t.Fatalf("expected an error:\n%s", pf.Src)
+			}
+			if !tc.Err.MatchString(err.Error()) {
+				t.Fatalf("%s didn't match %v", tc.Err, err)
+			}
+		})
+	}
+
+	if _, err := genx.New("", map[string]string{"type:KT": "int"}).Parse("src.go", src); err != nil {
+		t.Fatal(err)
+	}
+
+	// only whole words get renamed, the KT of KTXMap isn't one.
+	const words = `package x
+
+type KT interface{}
+
+type KTMap struct{ Key KT }
+
+type KTXMap struct{}
+
+type StringMap struct{}
+`
+	re := regexp.MustCompile(`^colliding identifiers:\n\tKTMap \(src\.go:5:6\) and StringMap \(src\.go:9:6\) are both renamed to StringMap$`)
+	if _, err := genx.New("", map[string]string{"type:KT": "string"}).Parse("src.go", words); err == nil || !re.MatchString(err.Error()) {
+		t.Fatalf("%s didn't match %v", re, err)
+	}
+}
+
+func TestUnused(t *testing.T) {
+	g := genx.New("", map[string]string{
+		"type:KT":         "string",
+		"type:VY":         "int",
+		"field:RemoveMe":  "-",
+		"field:Call":      "Fn",
+		"field:HashFm":    "-",
+		"func:DoStuff":    "DoOtherStuff",
+		"selector:b.Nope": "x.Nope",
+	})
+	if _, err := g.Parse("./all_types.go", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if unused, exp := g.Unused(), []string{"field:HashFm", "selector:b.Nope", "type:VY"}; !reflect.DeepEqual(unused, exp) {
+		t.Fatalf("expected %q, got %q", exp, unused)
+	}
+}
+
+func TestDeadCode(t *testing.T) {
+	const src = `package x
+
+type S struct {
+	X int
+	Y int
+}
+
+func (s S) GetX() int { return s.X }
+func (s S) GetY() int { return s.Y }
+
+func UseGetX(s S) int { return s.GetX() }
+
+func Exported() int { return helper() }
+
+func helper() int { return helper2() }
+func helper2() int { return 42 }
+
+func alreadyUnused() {}
+
+var ptrs = []func(S) int{UseGetX}
+`
+	testCases := []struct {
+		Name    string
+		Input   map[string]string
+		Keep    []string
+		Removed []string
+	}{
+		{
+			"Field",
+			map[string]string{"field:X": "-"},
+			nil,
+			[]string{"S.X (src.go:4:2)", "S.GetX (src.go:8:12)", "UseGetX (src.go:11:6)", "ptrs (src.go:20:5)"},
+		},
+		{
+			"Func",
+			map[string]string{"func:Exported": "-"},
+			nil,
+			[]string{"Exported (src.go:13:6)", "helper (src.go:15:6)", "helper2 (src.go:16:6)"},
+		},
+		{
+			"Keep",
+			nil,
+			[]string{"Exported", "S.GetY"},
+			[]string{"S.GetX (src.go:8:12)", "UseGetX (src.go:11:6)", "alreadyUnused (src.go:18:6)", "ptrs (src.go:20:5)"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			g := genx.New("", tc.Input)
+			g.Keep = tc.Keep
+			pf, err := g.Parse("src.go", src)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, pf.Src)
+			}
+			if removed := g.Removed(); !reflect.DeepEqual(removed, tc.Removed) {
+				t.Fatalf("expected %q, got %q\n%s", tc.Removed, removed, pf.Src)
+			}
+			typeCheck(t, "x", genx.ParsedPkg{pf})
+		})
+	}
+}
+
+func TestMethodRewriters(t *testing.T) {
+	const src = `package x
+
+type Keyer interface {
+	Keys() []string
+}
+
+type TSet map[string]struct{}
+
+func (s TSet) Keys() (out []string) {
+	for k := range s {
+		out = append(out, k)
+	}
+	return
+}
+
+type Other struct{}
+
+func (Other) Keys() int { return 0 }
+
+func KeysOf(k Keyer) []string { return k.Keys() }
+
+func All(s TSet, o Other) ([]string, func() []string, func(TSet) []string, int) {
+	return s.Keys(), s.Keys, TSet.Keys, o.Keys()
+}
+`
+	testCases := []struct {
+		Name        string
+		Input       map[string]string
+		FailIfMatch *regexp.Regexp
+		MustMatch   *regexp.Regexp
+	}{
+		{
+			"Rename",
+			map[string]string{"func:TSet.Keys": "SortedKeys"},
+			regexp.MustCompile(`\) Keys\(\) \[\]string|\tKeys\(\)|\b[ks]\.Keys\b|TSet\.Keys|o\.SortedKeys`),
+			regexp.MustCompile(`Other\) Keys\(\) int`),
+		},
+		{
+			"Remove",
+			map[string]string{"func:TSet.Keys": "-"},
+			regexp.MustCompile(`\(s TSet\) Keys|func All`),
+			regexp.MustCompile(`Other\) Keys\(\) int`),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			g := genx.New("", tc.Input)
+			pf, err := g.Parse("src.go", src)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, pf.Src)
+			}
+			if tc.FailIfMatch.Match(pf.Src) {
+				t.Fatalf("%s matched :(\n%s", tc.FailIfMatch, pf.Src)
+			}
+			if !tc.MustMatch.Match(pf.Src) {
+				t.Fatalf("%s didn't match :(\n%s", tc.MustMatch, pf.Src)
+			}
+			if unused := g.Unused(); len(unused) > 0 {
+				t.Fatalf("unused: %q", unused)
+			}
+			typeCheck(t, "x", genx.ParsedPkg{pf})
+		})
+	}
+}
+
+func TestMethodRewritersImplementers(t *testing.T) {
+	const src = `package x
+
+type Keyer interface {
+	Keys() []string
+}
+
+type TSet map[string]struct{}
+
+func (s TSet) Keys() (out []string) {
+	for k := range s {
+		out = append(out, k)
+	}
+	return
+}
+
+type List []string
+
+func (l List) Keys() []string { return l }
+
+type Wrapper struct{ List }
+
+var _ Keyer = List(nil)
+
+func Both(w Wrapper) ([]string, Keyer) { return w.Keys(), w }
+`
+	g := genx.New("", map[string]string{"func:TSet.Keys": "SortedKeys"})
+	pf, err := g.Parse("src.go", src)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, pf.Src)
+	}
+	if re := regexp.MustCompile(`\bKeys\(`); re.Match(pf.Src) {
+		t.Fatalf("%s matched :(\n%s", re, pf.Src)
+	}
+	typeCheck(t, "x", genx.ParsedPkg{pf})
+
+	g = genx.New("", map[string]string{"func:TSet.Keys": "SortedKeys", "func:List.Keys": "Items"})
+	if _, err := g.Parse("src.go", src); err == nil || !regexp.MustCompile(`conflicting renames`).MatchString(err.Error()) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+}
+
+func TestValueRewriters(t *testing.T) {
+	const src = `package x
+
+const answer = 42
+
+var expunged = new(int)
+
+func isExpunged(p *int) bool { return p == expunged }
+
+func IsExpunged(p *int) bool { return isExpunged(p) }
+
+func Answer() int { return answer }
+`
+	testCases := []struct {
+		Name        string
+		Input       map[string]string
+		FailIfMatch *regexp.Regexp
+		MustMatch   *regexp.Regexp
+	}{
+		{
+			"Remove",
+			map[string]string{"var:expunged": "-"},
+			regexp.MustCompile(`(?i)expunged`),
+			regexp.MustCompile(`return answer`),
+		},
+		{
+			"Rename",
+			map[string]string{"var:expunged": "deleted", "const:answer": "theAnswer"},
+			regexp.MustCompile(`\bexpunged\b|\banswer\b`),
+			regexp.MustCompile(`(?s)theAnswer = 42.*deleted = new.*p == deleted.*return theAnswer`),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			g := genx.New("", tc.Input)
+			pf, err := g.Parse("src.go", src)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, pf.Src)
+			}
+			if tc.FailIfMatch.Match(pf.Src) {
+				t.Fatalf("%s matched :(\n%s", tc.FailIfMatch, pf.Src)
+			}
+			if !tc.MustMatch.Match(pf.Src) {
+				t.Fatalf("%s didn't match :(\n%s", tc.MustMatch, pf.Src)
+			}
+			if unused := g.Unused(); len(unused) > 0 {
+				t.Fatalf("unused: %q", unused)
+			}
+			typeCheck(t, "x", genx.ParsedPkg{pf})
+		})
+	}
+}
+
+func TestConstValues(t *testing.T) {
+	const src = `package x
+
+type Size uint8
+
+const Shards = 32
+
+const (
+	ShardMask       = Shards - 1
+	small      Size = 4
+	big        Size = small * 16
+	notChanged      = 1 << 3
+)
+
+var shards [Shards]int
+
+func shardOf(h uint64) uint64 { return h & (Shards - 1) }
+func masked(h uint64) uint64  { return h & ShardMask }
+func smallest() Size          { return small * 2 }
+
+const (
+	ratio  = 0.5
+	thirds = ratio / 3
+	triple = ratio * 3
+)
+`
+	testCases := []struct {
+		Name  string
+		Input map[string]string
+		Match *regexp.Regexp
+		Err   *regexp.Regexp
+	}{
+		{
+			"Value",
+			map[string]string{"value:Shards": "64"},
+			regexp.MustCompile(`(?s)Shards = 64.*ShardMask\s+= 63.*notChanged\s+= 1 << 3.*\[Shards\]int.*h & 63.*h & ShardMask`),
+			nil,
+		},
+		{
+			"Const",
+			map[string]string{"const:Shards": "64"},
+			regexp.MustCompile(`(?s)Shards = 64.*ShardMask\s+= 63.*h & 63`),
+			nil,
+		},
+		{
+			"Expr",
+			map[string]string{"value:Shards": "1 << 8"},
+			regexp.MustCompile(`(?s)Shards = 1 << 8.*ShardMask\s+= 255.*h & 255`),
+			nil,
+		},
+		{
+			"Typed",
+			map[string]string{"value:small": "8"},
+			regexp.MustCompile(`(?s)small\s+Size = 8.*return Size\(16\)`),
+			nil,
+		},
+		{
+			"Ident",
+			map[string]string{"value:Shards": "notChanged"},
+			regexp.MustCompile(`(?s)Shards = notChanged.*ShardMask\s+= 7.*h & 7`),
+			nil,
+		},
+		{
+			"Float",
+			map[string]string{"value:ratio": "0.1"},
+			regexp.MustCompile(`(?s)ratio\s+= 0\.1\n.*thirds\s+= 1\.0 / 30\n.*triple\s+= 0\.3\n`),
+			nil,
+		},
+		{
+			"Inject",
+			map[string]string{"value:BufSize": "128"},
+			regexp.MustCompile(`const BufSize = 128`),
+			nil,
+		},
+		{
+			"Overflow",
+			map[string]string{"value:small": "300"},
+			nil,
+			regexp.MustCompile(`value:small=300: .*overflows.*src\.go:9:2`),
+		},
+		{
+			"WrongKind",
+			map[string]string{"value:Shards": `"many"`},
+			nil,
+			regexp.MustCompile(`value:Shards="many": untyped string can't be used as int`),
+		},
+		{
+			"DependentOverflow",
+			map[string]string{"value:small": "16"},
+			nil,
+			regexp.MustCompile(`big = small \* 16: .*overflows.*src\.go:10:2`),
 		},
 	}
-	src, err := ioutil.ReadFile("./all_types.go")
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			pf, err := genx.New("", tc.Input).Parse("src.go", src)
+			if tc.Err != nil {
+				if err == nil || !tc.Err.MatchString(err.Error()) {
+					t.Fatalf("expected %s, got %v\n%s", tc.Err, err, pf.Src)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%v\n%s", err, pf.Src)
+			}
+			if !tc.Match.Match(pf.Src) {
+				t.Fatalf("%s didn't match :(\n%s", tc.Match, pf.Src)
+			}
+			typeCheck(t, "x", genx.ParsedPkg{pf})
+		})
+	}
+}
+
+func TestConstraints(t *testing.T) {
+	const src = `package x
+
+import "fmt"
+
+//genx:constraint KT comparable
+//genx:constraint OT ordered
+//genx:constraint ST fmt.Stringer
+//genx:constraint NT, VT Number
+type (
+	KT interface{}
+	OT interface{}
+	ST interface{}
+	NT interface{}
+	VT interface{}
+)
+
+type Number interface {
+	~int | ~int64 | ~float64
+}
+
+type MyInt int
+
+func (MyInt) String() string { return "" }
+
+var _ fmt.Stringer
+`
+	testCases := []struct {
+		Name  string
+		Input map[string]string
+		Err   *regexp.Regexp
+	}{
+		{"Valid", map[string]string{"type:KT": "[2]int", "type:OT": "string", "type:ST": "MyInt", "type:NT": "MyInt", "type:VT": "float64"}, nil},
+		{"Comparable", map[string]string{"type:KT": "[]byte"}, regexp.MustCompile(`^x: KT=\[\]byte doesn't satisfy comparable \(src\.go:5:1\)$`)},
+		{"Ordered", map[string]string{"type:OT": "bool"}, regexp.MustCompile(`OT=bool doesn't satisfy ordered \(src\.go:6:1\)`)},
+		{"Stringer", map[string]string{"type:ST": "int"}, regexp.MustCompile(`ST=int doesn't satisfy fmt\.Stringer`)},
+		{"Local", map[string]string{"type:VT": "string"}, regexp.MustCompile(`VT=string doesn't satisfy Number \(src\.go:8:1\)`)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			pf, err := genx.New("", tc.Input).Parse("src.go", src)
+			if tc.Err == nil {
+				if err != nil {
+					t.Fatalf("%v\n%s", err, pf.Src)
+				}
+				if bytes.Contains(pf.Src, []byte("genx:constraint")) {
+					t.Fatalf("the directives should've been removed:\n%s", pf.Src)
+				}
+				return
+			}
+			if err == nil || !tc.Err.MatchString(err.Error()) {
+				t.Fatalf("expected %s, got %v\n%s", tc.Err, err, pf.Src)
+			}
+		})
+	}
+}
+
+func TestPlaceholderBoundaries(t *testing.T) {
+	const src = `package x
+
+type (
+	KT interface{}
+	VT interface{}
+)
+
+type Tree struct{ K KT }
+
+type KTVT map[KT]VT
+
+type TCPConn struct{}
+
+var expungedVT, KT_zero, KTs, KTree int
+
+func NewKTVT() KTVT { return KTVT{} }
+
+func SortKT__s(s []KT) {}
+
+func my__KT__Func() {}
+`
+	g := genx.New("", map[string]string{"type:KT": "string", "type:VT": "int", "type:T": "bool"})
+	pf, err := g.Parse("src.go", src)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, pf.Src)
+	}
+	for _, exp := range []string{"type Tree struct", "type StringInt map[string]int", "type TCPConn struct", "expungedInt, String_zero, Strings, KTree",
+		"func NewStringInt() StringInt", "func SortStrings(", "func myStringFunc("} {
+		if !bytes.Contains(pf.Src, []byte(exp)) {
+			t.Errorf("expected %q in:\n%s", exp, pf.Src)
+		}
+	}
+
+	renames := g.Renames()
+	for _, exp := range []string{"KTVT => StringInt", "SortKT__s => SortStrings", "KTs => Strings", "KTree (not renamed: KT, T aren't whole words, use __ to glue them)", "Tree (not renamed: T isn't a whole word, use __ to glue it)"} {
+		found := false
+		for _, r := range renames {
+			found = found || r == exp
+		}
+		if !found {
+			t.Errorf("expected %q in %q", exp, renames)
+		}
+	}
+}
+
+func TestNaming(t *testing.T) {
+	const src = `package x
+
+type (
+	KT interface{}
+	VT interface{}
+)
+
+// KTVT maps KT to VT, NewKTVT returns a new one.
+type KTVT map[KT]VT
+
+func NewKTVT() KTVT { return KTVT{} }
+`
+	g := genx.New("", map[string]string{"type:KT": "interface{}", "type:VT": "complex64"})
+	g.Naming = genx.NamingTable(map[string]string{"interface{}": "Any"})
+	pf, err := g.Parse("src.go", src)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, pf.Src)
+	}
+	for _, exp := range []string{"// AnyCmplx64 maps interface{} to complex64, NewAnyCmplx64 returns a new one.",
+		"type AnyCmplx64 map[interface{}]complex64", "func NewAnyCmplx64() AnyCmplx64"} {
+		if !bytes.Contains(pf.Src, []byte(exp)) {
+			t.Errorf("expected %q in:\n%s", exp, pf.Src)
+		}
+	}
+}
+
+func TestCommentRewriters(t *testing.T) {
+	const src = `package x
+
+type KT interface{}
+
+// KTSet is a set of KT, Keys returns its keys and Len its size.
+type KTSet struct{ m map[KT]struct{} }
+
+// Keys returns the keys of a KTSet.
+func (s KTSet) Keys() (out []KT) { return }
+
+// Len returns the number of keys.
+func (s KTSet) Len() int { return len(s.m) }
+`
+	g := genx.New("", map[string]string{"type:KT": "string", "func:Keys": "Items", "field:m": "Set"})
+	pf, err := g.Parse("src.go", src)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, pf.Src)
+	}
+	for _, exp := range []string{"// StringSet is a set of string, Keys returns its keys and Len its size.",
+		"// Keys returns the keys of a StringSet.", "func (s StringSet) Items() (out []string)", "// Len returns the number of keys."} {
+		if !bytes.Contains(pf.Src, []byte(exp)) {
+			t.Errorf("expected %q in:\n%s", exp, pf.Src)
+		}
+	}
+}
+
+func TestCompositeTypeArgs(t *testing.T) {
+	const src = `package x
+
+type (
+	T  interface{}
+	CT interface{}
+	F  interface{}
+)
+
+func Conv(v interface{}) T { return T(v.(T)) }
+
+var Chans chan CT
+
+type Handlers map[string]F
+
+func NewCT() CT { return nil }
+`
+	g := genx.New("", map[string]string{"type:T": "*github.com/x/geom#g.Point", "type:CT": "<-chan int", "type:F": "func(a, b int) error"})
+	pf, err := g.Parse("src.go", src)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, pf.Src)
+	}
+	for _, exp := range []string{`g "github.com/x/geom"`, "func Conv(v interface{}) *g.Point { return (*g.Point)(v.(*g.Point)) }",
+		"var Chans chan (<-chan int)", "type Handlers map[string]func(a, b int) error", "func NewIntRecvChan() <-chan int"} {
+		if !bytes.Contains(pf.Src, []byte(exp)) {
+			t.Errorf("expected %q in:\n%s", exp, pf.Src)
+		}
+	}
+
+	for v, exp := range map[string]string{
+		"map[string][]int":            "MapStringIntSlice",
+		"*github.com/x/geom.Point":    "GeomPoint",
+		"func(a, b int) error":        "FuncIntIntError",
+		"chan<- [4]byte":              "ByteArray4SendChan",
+		"gopkg.in/yaml.v2.MapSlice":   "YamlMapSlice",
+		"github.com/x/list.List[int]": "ListListInt",
+	} {
+		if name := genx.DefaultNaming(v); name != exp {
+			t.Errorf("%s: expected %s, got %s", v, exp, name)
+		}
+	}
+
+	for _, v := range []string{"map[string", "1 + 2", "github.com/x/go-geom.Point"} {
+		if _, err := genx.New("", map[string]string{"type:T": v}).Parse("src.go", src); err == nil {
+			t.Errorf("%s: expected an error", v)
+		}
+	}
+}
+
+func TestZeroValues(t *testing.T) {
+	const src = `package x
+
+type (
+	KT interface{}
+	VT interface{}
+)
+
+type Pair struct {
+	K KT
+	V VT
+}
+
+type Point struct{ X, Y int }
+
+func Get(m map[KT]VT, k KT) (v VT, ok bool) {
+	if v, ok = m[k]; !ok {
+		v = nil
+	}
+	return
+}
+
+func Find(vs []VT, fn func(VT) bool) VT {
+	first := func() VT {
+		if len(vs) == 0 {
+			return nil
+		}
+		return vs[0]
+	}
+	for _, v := range vs {
+		if v != nil && fn(v) {
+			return v
+		}
+	}
+	return first()
+}
+
+func Defaults() ([]VT, Pair, *VT) {
+	return []VT{nil}, Pair{K: nil, V: nil}, nil
+}
+`
+	testCases := []struct {
+		Name   string
+		Input  map[string]string
+		Expect []string
+	}{
+		{"Builtin", map[string]string{"type:KT": "string", "type:VT": "float64"},
+			[]string{"v = 0\n", "return 0\n", "v != 0 &&", `[]float64{0}, Pair{K: "", V: 0}, nil`}},
+		{"Struct", map[string]string{"type:KT": "bool", "type:VT": "Point"},
+			[]string{"v = Point{}", "return Point{}", "v != (Point{}) &&", `[]Point{Point{}}, Pair{K: false, V: Point{}}, nil`}},
+		{"Unresolved", map[string]string{"type:KT": "int8", "type:VT": "image.Point"},
+			[]string{"v = *new(image.Point)", "v != *new(image.Point) &&", `Pair{K: 0, V: *new(image.Point)}`}},
+		{"Nillable", map[string]string{"type:KT": "*int", "type:VT": "[]byte"},
+			[]string{"v = nil", "return nil", "v != nil &&", `[][]byte{nil}, Pair{K: nil, V: nil}, nil`}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			pf, err := genx.New("", tc.Input).Parse("src.go", src)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, pf.Src)
+			}
+			for _, exp := range tc.Expect {
+				if !bytes.Contains(pf.Src, []byte(exp)) {
+					t.Errorf("expected %q in:\n%s", exp, pf.Src)
+				}
+			}
+			if bytes.Contains(pf.Src, []byte("zero_")) {
+				t.Errorf("unexpected zero_ var:\n%s", pf.Src)
+			}
+			typeCheck(t, "x", genx.ParsedPkg{pf})
+		})
+	}
+}
+
+func TestIntrinsics(t *testing.T) {
+	const src = `package x
+
+import "github.com/OneOfOne/genx/intrinsics"
+
+type T interface{}
+
+type Version struct{ Major, Minor int }
+
+func (v Version) Less(o Version) bool { return v.Major < o.Major || v.Major == o.Major && v.Minor < o.Minor }
+
+func Max(a, b T) T {
+	if !intrinsics.Less(a, b) {
+		return a
+	}
+	return b
+}
+
+func Index(s []T, v T) int {
+	for i := range s {
+		if intrinsics.Equal(s[i], v) {
+			return i
+		}
+	}
+	return -1
+}
+
+func Reset(v *T) bool {
+	*v = intrinsics.Zero[T]()
+	return intrinsics.IsBuiltin("T")
+}
+
+func Min[E any](a, b E) E {
+	if intrinsics.Less(b, a) {
+		return b
+	}
+	return a
+}
+`
+	testCases := []struct {
+		Name   string
+		Input  map[string]string
+		Expect []string
+		Err    *regexp.Regexp
+	}{
+		{"Builtin", map[string]string{"type:T": "int", "type:E": "string"},
+			[]string{`!(a < b)`, `s[i] == v`, "*v = 0\n", `return true`, `b < a`}, nil},
+		{"Custom", map[string]string{"type:T": "Version", "type:E": "Version"},
+			[]string{`!a.Less(b)`, `s[i] == v`, `*v = Version{}`, `return false`, `b.Less(a)`}, nil},
+		{"Conversion", map[string]string{"type:T": "float32", "type:E": "float64"},
+			[]string{`*v = float32(0)`, `b < a`}, nil},
+		{"Unordered", map[string]string{"type:T": "[]byte", "type:E": "int"}, nil,
+			regexp.MustCompile(`^intrinsics\.Less: \[\]byte isn't ordered and doesn't have a Less method \(src\.go:12:6\)$`)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			pf, err := genx.New("", tc.Input).Parse("src.go", src)
+			if tc.Err != nil {
+				if err == nil || !tc.Err.MatchString(err.Error()) {
+					t.Fatalf("expected %s, got %v\n%s", tc.Err, err, pf.Src)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%v\n%s", err, pf.Src)
+			}
+			for _, exp := range tc.Expect {
+				if !bytes.Contains(pf.Src, []byte(exp)) {
+					t.Errorf("expected %q in:\n%s", exp, pf.Src)
+				}
+			}
+			if bytes.Contains(pf.Src, []byte("intrinsics")) {
+				t.Errorf("the intrinsics should've been expanded:\n%s", pf.Src)
+			}
+			typeCheck(t, "x", genx.ParsedPkg{pf})
+		})
+	}
+
+	const zeroSrc = `package x
+
+import "github.com/OneOfOne/genx/intrinsics"
+
+type T interface{}
+
+func Reset(v *T) bool {
+	*v = intrinsics.Zero[T]()
+	return intrinsics.IsBuiltin("T")
+}
+`
+	for typ, exp := range map[string]string{
+		"[]int":          "*v = *new([]int)\n\treturn false",
+		"*int":           "*v = *new(*int)\n\treturn false",
+		"map[string]int": "*v = *new(map[string]int)\n\treturn false",
+		"time.Time":      "*v = *new(time.Time)\n\treturn false",
+		"[]byte":         "*v = *new([]byte)\n\treturn true",
+	} {
+		pf, err := genx.New("", map[string]string{"type:T": typ}).Parse("src.go", zeroSrc)
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", typ, err, pf.Src)
+		}
+		if !bytes.Contains(pf.Src, []byte(exp)) {
+			t.Errorf("%s: expected %q in:\n%s", typ, exp, pf.Src)
+		}
+		typeCheck(t, "x", genx.ParsedPkg{pf})
+	}
+}
+
+func TestDeadBranches(t *testing.T) {
+	const src = `package x
+
+import (
+	"fmt"
+
+	"github.com/OneOfOne/genx/intrinsics"
+)
+
+type T interface{}
+
+const debug = false
+
+func String(v T) string {
+	if intrinsics.IsBuiltin("T") {
+		return fmt.Sprint(v)
+	} else if s, ok := any(v).(fmt.Stringer); ok {
+		return s.String()
+	}
+	return "?"
+}
+
+func Kind(v T) string {
+	switch x := any(v).(type) {
+	case int, int64:
+		return "int"
+	case string:
+		return "string:" + x
+	case fmt.Stringer:
+		return "stringer:" + x.String()
+	default:
+		return "other"
+	}
+}
+
+func Size(v T) (n int) {
+	if debug && n > 0 {
+		fmt.Println("size", v)
+	}
+	switch {
+	case intrinsics.IsBuiltin("T") && n == 0:
+		n = 1
+	default:
+		n = 2
+	}
+	return
+}
+
+func Len(v T) int {
+	n := len(fmt.Sprint(v))
+	if intrinsics.IsBuiltin("T") {
+		return 1
+	}
+	return n
+}
+`
+	testCases := []struct {
+		Name   string
+		Input  map[string]string
+		Expect []string
+		Gone   []string
+	}{
+		{"Int", map[string]string{"type:T": "int"},
+			[]string{`return fmt.Sprint(v)`, `return "int"`, `case n == 0:`, `if debug && n > 0 {`, "return 1\n\t}\n\treturn n"},
+			[]string{`Stringer`, `"string:"`, `"other"`}},
+		{"String", map[string]string{"type:T": "string"},
+			[]string{`x := v`, `return "string:" + x`},
+			[]string{`return "int"`, `"stringer:"`, `.(type)`}},
+		{"Stringer", map[string]string{"type:T": "time.Duration"},
+			[]string{`if s, ok := any(v).(fmt.Stringer); ok {`, `var x fmt.Stringer = v`, "\tn = 2\n"},
+			[]string{`fmt.Sprint(v)\n`, `"string:"`, `"other"`, `n = 1`, `switch`, `return 1`}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			pf, err := genx.New("", tc.Input).Parse("src.go", src)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, pf.Src)
+			}
+			for _, exp := range tc.Expect {
+				if !bytes.Contains(pf.Src, []byte(exp)) {
+					t.Errorf("expected %q in:\n%s", exp, pf.Src)
+				}
+			}
+			for _, exp := range tc.Gone {
+				if bytes.Contains(pf.Src, []byte(exp)) {
+					t.Errorf("%q should've been removed:\n%s", exp, pf.Src)
+				}
+			}
+			typeCheck(t, "x", genx.ParsedPkg{pf})
+		})
+	}
+}
+
+func runRewriteCases(t *testing.T, fname string, testCases []rewriteCase) {
+	src, err := ioutil.ReadFile(fname)
 	fatalIf(t, err)
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
@@ -82,6 +1059,79 @@ func TestAllTypes(t *testing.T) {
 	}
 }
 
+func TestCheckOutput(t *testing.T) {
+	const src = `package x
+
+type T interface{}
+
+func Sum(vals ...T) (out T) {
+	for _, v := range vals {
+		out += v
+	}
+	return out + Zero
+}
+`
+	for typ, exp := range map[string]string{
+		"int":  "",
+		"bool": `src\.go:7:3: invalid operation: operator \+ not defined on out \(variable of type bool\) \(template src\.go:7:3\)`,
+	} {
+		g := genx.New("", map[string]string{"type:T": typ})
+		g.CheckOutput = true
+		pf, err := g.Parse("src.go", src)
+		if exp == "" {
+			fatalIf(t, err)
+			continue
+		}
+
+		var errs genx.OutputErrors
+		if !errors.As(err, &errs) {
+			t.Fatalf("%s: expected OutputErrors, got %v:\n%s", typ, err, pf.Src)
+		}
+		// Zero is undefined in the template too, so it isn't the output's problem.
+		if len(errs) != 1 || !regexp.MustCompile(exp).MatchString(errs[0].Error()) {
+			t.Fatalf("%s: expected %s, got %v:\n%s", typ, exp, err, pf.Src)
+		}
+	}
+
+	// the template can't import it either.
+	g := genx.New("", map[string]string{"type:T": "int"})
+	g.CheckOutput = true
+	pf, err := g.Parse("src.go", "package x\n\nimport \"example.com/nope\"\n\ntype T interface{}\n\nvar V T = nope.V\n")
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, pf.Src)
+	}
+
+	// types local to the output's package are declared next to it.
+	g = genx.New("mypkg", map[string]string{"type:T": "YourType"})
+	g.CheckOutput = true
+	pkg, err := g.ParsePkg("./seeds/set", false)
+	fatalIf(t, err)
+	if !regexp.MustCompile(`type YourTypeSet map\[YourType\]struct\{\}`).Match(pkg[0].Src) {
+		t.Errorf("expected a YourTypeSet:\n%s", pkg[0].Src)
+	}
+}
+
+// typeCheck fails t if pkg doesn't compile.
+func typeCheck(t *testing.T, name string, pkg genx.ParsedPkg) {
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, pf := range pkg {
+		f, err := parser.ParseFile(fset, pf.Name, pf.Src, 0)
+		if err != nil {
+			t.Fatalf("%v\n%s", err, pf.Src)
+		}
+		files = append(files, f)
+	}
+
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check(name, fset, files, nil); err != nil {
+		for _, pf := range pkg {
+			t.Logf("%s:\n%s", pf.Name, pf.Src)
+		}
+		t.Fatal(err)
+	}
+}
+
 func fatalIf(t *testing.T, err error) {
 	if err != nil {
 		t.Fatal(err)
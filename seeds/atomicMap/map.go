@@ -11,6 +11,7 @@ import (
 	"unsafe"
 )
 
+//genx:constraint KT comparable
 type (
 	KT interface{}
 	VT interface{}
@@ -0,0 +1,23 @@
+// Package seeds embeds the seed templates so they can be used without the source of genx, see GenX.FS.
+package seeds
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// FS has every seed in its own directory, ex: set/set.go.
+//
+//go:embed atomicMap atomicValue set sort
+var FS embed.FS
+
+// Names returns the names of the seeds in FS.
+func Names() (out []string) {
+	entries, _ := fs.ReadDir(FS, ".")
+	for _, e := range entries {
+		if e.IsDir() {
+			out = append(out, e.Name())
+		}
+	}
+	return
+}
@@ -0,0 +1,15 @@
+package set_test
+
+import (
+	"fmt"
+
+	"github.com/OneOfOne/genx/seeds/set"
+)
+
+func ExampleTSet() {
+	var v set.T
+	s := set.NewTSet()
+	s.Set(v)
+	fmt.Println(s.Has(v), len(s.Keys()))
+	// Output: true 1
+}
@@ -1,5 +1,6 @@
 package set
 
+//genx:constraint T comparable
 type T interface{}
 
 type TSet map[T]struct{}
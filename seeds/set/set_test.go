@@ -0,0 +1,19 @@
+package set
+
+import "testing"
+
+func TestTSet(t *testing.T) {
+	var v T
+	s := NewTSet()
+	s.Set(v)
+	if !s.Has(v) || len(s.Keys()) != 1 {
+		t.Fatalf("expected %v to be set: %v", v, s)
+	}
+
+	o := NewTSet()
+	o.Merge(s)
+	o.Unset(v)
+	if o.Has(v) || !s.Has(v) {
+		t.Fatalf("expected %v to only be unset in %v", v, o)
+	}
+}
@@ -0,0 +1,37 @@
+// +build ignore
+
+package genx
+
+import U "strings"
+
+type (
+	KT interface{}
+	VT interface{}
+	T  interface{}
+)
+
+// FieldsNamedKT has fields and methods named like the placeholders.
+type FieldsNamedKT struct {
+	KT  int
+	T   string
+	Key KT
+}
+
+func (f *FieldsNamedKT) SetKT(v KT) { f.Key = v }
+
+func (f FieldsNamedKT) Sum() int {
+	return f.KT + len(f.T)
+}
+
+func LocalShadow(v VT) VT {
+	KT := 1
+	type T struct{ VT int }
+	_ = T{VT: KT}
+	return v
+}
+
+func Upper(s string) string { return U.ToUpper(s) }
+
+func Renamed() int { return NotRenamed(1) }
+
+func NotRenamed(Renamed int) int { return Renamed }
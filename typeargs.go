@@ -0,0 +1,245 @@
+package genx
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/OneOfOne/xast"
+)
+
+// qualifiedRE matches the names qualified by an import path, ex: github.com/OneOfOne/cmap/hashers#h.Fnv32.
+var qualifiedRE = regexp.MustCompile(`((?:[\w.-]+/)+)([\w.-]+)(?:#(\w+))?\.([\pL_][\pL\pN_]*)`)
+
+// qualify replaces the names qualified by an import path in v with the package name or the alias (ex: #h) and returns
+// the imports they need, string literals are left alone.
+func qualify(v string) (src string, imports map[string]string, err error) {
+	var (
+		buf   strings.Builder
+		quote byte
+		start int
+	)
+	flush := func(end int) {
+		buf.WriteString(qualifiedRE.ReplaceAllStringFunc(v[start:end], func(m string) string {
+			p := qualifiedRE.FindStringSubmatch(m)
+			path, alias, name := p[1]+p[2], p[3], p[4]
+			pkg := alias
+			if pkg == "" {
+				// ex: gopkg.in/yaml.v2 is yaml
+				pkg = strings.SplitN(p[2], ".", 2)[0]
+			}
+			if !token.IsIdentifier(pkg) {
+				if err == nil {
+					err = fmt.Errorf("can't guess the package name of %s, use %s#name.%s", path, path, name)
+				}
+				return m
+			}
+			if imports == nil {
+				imports = map[string]string{}
+			}
+			imports[path] = alias
+			return pkg + "." + name
+		}))
+	}
+
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				buf.WriteString(v[start : i+1])
+				quote, start = 0, i+1
+			}
+		case c == '"' || c == '\'' || c == '`':
+			flush(i)
+			quote, start = c, i
+		}
+	}
+	if quote == 0 {
+		flush(len(v))
+	} else {
+		buf.WriteString(v[start:])
+	}
+	return buf.String(), imports, err
+}
+
+// parseTypeArg parses the type argument v, which can use import paths (ex: map[string][]github.com/x/pkg.Foo).
+func parseTypeArg(v string) (x ast.Expr, src string, imports map[string]string, err error) {
+	if src, imports, err = qualify(v); err != nil {
+		return
+	}
+	if x, err = parser.ParseExpr(src); err != nil {
+		return nil, src, imports, fmt.Errorf("invalid type %s: %v", v, err)
+	}
+	if !isTypeExpr(x) {
+		return nil, src, imports, fmt.Errorf("%s isn't a type", v)
+	}
+	return
+}
+
+// isTypeExpr reports whether x is syntactically a type.
+func isTypeExpr(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return true
+	case *ast.SelectorExpr:
+		_, ok := x.X.(*ast.Ident)
+		return ok
+	case *ast.ParenExpr:
+		return isTypeExpr(x.X)
+	case *ast.StarExpr:
+		return isTypeExpr(x.X)
+	case *ast.ArrayType:
+		return isTypeExpr(x.Elt)
+	case *ast.MapType:
+		return isTypeExpr(x.Key) && isTypeExpr(x.Value)
+	case *ast.ChanType:
+		return isTypeExpr(x.Value)
+	case *ast.IndexExpr:
+		return isTypeExpr(x.X) && isTypeExpr(x.Index)
+	case *ast.IndexListExpr:
+		for _, idx := range x.Indices {
+			if !isTypeExpr(idx) {
+				return false
+			}
+		}
+		return isTypeExpr(x.X)
+	case *ast.FuncType, *ast.StructType, *ast.InterfaceType:
+		return true
+	}
+	return false
+}
+
+// typeName returns the name of the type x inside identifiers, ex: map[string][]int => MapStringIntSlice.
+func typeName(x ast.Expr) string {
+	if a := builtins[types.ExprString(x)]; a != "" {
+		return a
+	}
+
+	switch x := x.(type) {
+	case *ast.Ident:
+		return strings.Title(x.Name)
+	case *ast.SelectorExpr:
+		return typeName(x.X) + typeName(x.Sel)
+	case *ast.ParenExpr:
+		return typeName(x.X)
+	case *ast.StarExpr:
+		return typeName(x.X)
+	case *ast.Ellipsis:
+		return typeName(x.Elt) + "Slice"
+	case *ast.ArrayType:
+		switch l := x.Len.(type) {
+		case nil:
+			return typeName(x.Elt) + "Slice"
+		case *ast.BasicLit:
+			return typeName(x.Elt) + "Array" + l.Value
+		}
+		return typeName(x.Elt) + "Array"
+	case *ast.MapType:
+		return "Map" + typeName(x.Key) + typeName(x.Value)
+	case *ast.ChanType:
+		switch x.Dir {
+		case ast.RECV:
+			return typeName(x.Value) + "RecvChan"
+		case ast.SEND:
+			return typeName(x.Value) + "SendChan"
+		}
+		return typeName(x.Value) + "Chan"
+	case *ast.FuncType:
+		name := "Func" + fieldsName(x.Params)
+		if x.Results != nil {
+			name += fieldsName(x.Results)
+		}
+		return name
+	case *ast.IndexExpr:
+		return typeName(x.X) + typeName(x.Index)
+	case *ast.IndexListExpr:
+		name := typeName(x.X)
+		for _, idx := range x.Indices {
+			name += typeName(idx)
+		}
+		return name
+	case *ast.StructType:
+		return "Struct"
+	case *ast.InterfaceType:
+		return "Interface"
+	}
+	return cleanUpName.ReplaceAllString(strings.Title(types.ExprString(x)), "")
+}
+
+// fieldsName returns the names of the types of fl, once per field, ex: (a, b int) => IntInt.
+func fieldsName(fl *ast.FieldList) (name string) {
+	for _, f := range fl.List {
+		n := typeName(f.Type)
+		name += n
+		for i := 1; i < len(f.Names); i++ {
+			name += n
+		}
+	}
+	return
+}
+
+// setType replaces the placeholder node with the type expression src,
+// adding the parentheses the expression needs where it's used (ex: (*Foo)(x) or chan (<-chan int)).
+func (g *GenX) setType(node *xast.Node, src string) *xast.Node {
+	n := node.Node().(*ast.Ident)
+	x, err := parser.ParseExpr(src)
+	if err != nil {
+		n.Name = src
+		return node
+	}
+	if _, ok := x.(*ast.Ident); ok {
+		n.Name = src
+		return node
+	}
+
+	// the positions of the parsed expression are meaningless in the template, they all get the placeholder's.
+	ast.Inspect(x, func(c ast.Node) bool {
+		if c == nil {
+			return false
+		}
+		g.visited[c] = true
+		v := reflect.ValueOf(c).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Field(i); f.Type() == posType {
+				f.SetInt(int64(n.Pos()))
+			}
+		}
+		return true
+	})
+
+	switch p := node.Parent().Node().(type) {
+	case *ast.CallExpr:
+		if p.Fun == n && needsParens(x) {
+			x = &ast.ParenExpr{X: x}
+		}
+	case *ast.SelectorExpr:
+		if p.X == n && needsParens(x) {
+			x = &ast.ParenExpr{X: x}
+		}
+	case *ast.ChanType:
+		if ct, ok := x.(*ast.ChanType); ok && ct.Dir == ast.RECV && p.Dir == ast.SEND|ast.RECV {
+			x = &ast.ParenExpr{X: x}
+		}
+	}
+	return node.SetNode(x)
+}
+
+var posType = reflect.TypeOf(token.NoPos)
+
+// needsParens reports whether x has to be wrapped in parentheses to be converted to or used in a method expression.
+func needsParens(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.StarExpr, *ast.FuncType:
+		return true
+	case *ast.ChanType:
+		return x.Dir == ast.RECV
+	}
+	return false
+}
@@ -0,0 +1,330 @@
+package genx
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"sync"
+)
+
+// the source importer is slow, so imported packages are shared between all the type checks.
+var (
+	importMu    sync.Mutex
+	srcImporter = importer.ForCompiler(token.NewFileSet(), "source", nil)
+)
+
+type sharedImporter struct{}
+
+func (sharedImporter) Import(path string) (*types.Package, error) {
+	importMu.Lock()
+	defer importMu.Unlock()
+	return srcImporter.Import(path)
+}
+
+// checkTypes type-checks the template so the rewriters can tell the placeholders apart from
+// unrelated identifiers that happen to share their names.
+// Errors are ignored since templates rarely compile on their own (ex: genny's generic.Type).
+func (g *GenX) checkTypes(fset *token.FileSet, files []*ast.File) {
+	if len(files) == 0 {
+		return
+	}
+
+	g.info = &types.Info{
+		Types:      map[ast.Expr]types.TypeAndValue{},
+		Defs:       map[*ast.Ident]types.Object{},
+		Uses:       map[*ast.Ident]types.Object{},
+		Selections: map[*ast.SelectorExpr]*types.Selection{},
+		Instances:  map[*ast.Ident]types.Instance{},
+	}
+
+	g.tmplErrs = map[string]bool{}
+	conf := types.Config{
+		Importer: g.importer,
+		Error: func(err error) {
+			if te, ok := err.(types.Error); ok {
+				g.tmplErrs[errKey(te.Fset.Position(te.Pos), te.Msg)] = true
+			}
+		},
+	}
+	if conf.Importer == nil {
+		conf.Importer = sharedImporter{}
+	}
+
+	// the constraints and intrinsics need the imports of the type arguments, which the template doesn't have.
+	checked := files
+	cons := findConstraints(files)
+	g.fset, g.argsPos = fset, token.NoPos
+	if len(cons) > 0 || usesIntrinsics(files) {
+		if imports := g.importsFile(fset, files[0].Name.Name); imports != nil {
+			checked = append(files[:len(files):len(files)], imports)
+			g.argsPos = imports.Name.End()
+		}
+	}
+
+	g.pkg, _ = conf.Check(files[0].Name.Name, fset, checked, g.info)
+	g.tmplImports = importPositions(fset, files)
+	g.checkConstraints(fset, cons)
+	g.prepareTypeParams(files)
+	g.irepl.words = g.typeNames()
+	g.crepl.words = g.irepl.words
+	g.nils = g.nilTypes(files)
+	g.prepareMethodRenames()
+	g.prepareConsts(fset, files)
+	g.fixed = g.fixedConsts(files)
+	g.findDeadDecls(fset, files)
+}
+
+// typeNames returns the names of the template's package level types.
+func (g *GenX) typeNames() (out []string) {
+	if g.pkg == nil {
+		return nil
+	}
+	for _, name := range g.pkg.Scope().Names() {
+		if _, ok := g.pkg.Scope().Lookup(name).(*types.TypeName); ok {
+			out = append(out, name)
+		}
+	}
+	return
+}
+
+// objectOf returns the object n refers to or nil if it is unknown.
+func (g *GenX) objectOf(n *ast.Ident) types.Object {
+	if g.info == nil || n == nil {
+		return nil
+	}
+	// embedded fields are both defined and used, we want the type.
+	if obj := g.info.Uses[n]; obj != nil {
+		return obj
+	}
+	return g.info.Defs[n]
+}
+
+// isPkgType reports whether n refers to a package level type.
+// Identifiers the type checker couldn't resolve are assumed to be.
+func (g *GenX) isPkgType(n *ast.Ident) bool {
+	switch obj := g.objectOf(n).(type) {
+	case nil:
+		return true
+	case *types.TypeName:
+		return obj.Parent() == g.pkg.Scope() || obj.Parent() == types.Universe
+	}
+	return false
+}
+
+// typeArg returns the type n should be replaced with if it's an instantiated type parameter.
+func (g *GenX) typeArg(n *ast.Ident) (string, bool) {
+	name, ok := g.typeArgs[g.objectOf(n)]
+	if !ok {
+		return "", false
+	}
+	return g.rewriters["type:"+name], true
+}
+
+// isField reports whether n refers to a struct field.
+// Identifiers the type checker couldn't resolve are assumed to be.
+func (g *GenX) isField(n *ast.Ident) bool {
+	switch obj := g.objectOf(n).(type) {
+	case nil:
+		return true
+	case *types.Var:
+		return obj.IsField()
+	}
+	return false
+}
+
+// isPkgName reports whether n refers to an imported package.
+// Identifiers the type checker couldn't resolve are assumed to be.
+func (g *GenX) isPkgName(n *ast.Ident) bool {
+	switch g.objectOf(n).(type) {
+	case nil, *types.PkgName:
+		return true
+	}
+	return false
+}
+
+// isPkgDecl reports whether n refers to a package level declaration, a method or an embedded field
+// of the template, those are the only identifiers that get automatically renamed.
+// Identifiers the type checker couldn't resolve are assumed to be.
+func (g *GenX) isPkgDecl(n *ast.Ident) bool {
+	obj := g.objectOf(n)
+	if obj == nil {
+		return true
+	}
+
+	if obj.Pkg() != g.pkg {
+		return false
+	}
+
+	switch obj := obj.(type) {
+	case *types.PkgName:
+		return false
+	case *types.Var:
+		if obj.IsField() {
+			return obj.Embedded()
+		}
+	case *types.Func:
+		if obj.Type().(*types.Signature).Recv() != nil {
+			return true
+		}
+	}
+
+	return obj.Parent() == g.pkg.Scope()
+}
+
+// namedTypeOf returns the name of the named type of x, dereferencing pointers, or "" if it doesn't have one.
+func (g *GenX) namedTypeOf(x ast.Expr) string {
+	if g.info == nil {
+		return ""
+	}
+	t := g.info.TypeOf(x)
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	if n, ok := t.(*types.Named); ok && n.Obj().Pkg() == g.pkg {
+		return n.Obj().Name()
+	}
+	return ""
+}
+
+// nilTypes returns the type each `nil` in files is used as,
+// which the type checker doesn't record since it's untyped.
+func (g *GenX) nilTypes(files []*ast.File) map[*ast.Ident]types.Type {
+	out := map[*ast.Ident]types.Type{}
+	if g.info == nil {
+		return out
+	}
+
+	set := func(x ast.Expr, t types.Type) {
+		if id, ok := x.(*ast.Ident); ok && id.Name == "nil" && t != nil {
+			if _, ok := g.info.Uses[id].(*types.Nil); ok {
+				out[id] = t
+			}
+		}
+	}
+
+	var sigs []*types.Signature
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			if n.Body == nil {
+				return false
+			}
+			sig, _ := g.info.Defs[n.Name].Type().(*types.Signature)
+			sigs = append(sigs, sig)
+			ast.Inspect(n.Body, visit)
+			sigs = sigs[:len(sigs)-1]
+			return false
+
+		case *ast.FuncLit:
+			sig, _ := g.info.TypeOf(n).(*types.Signature)
+			sigs = append(sigs, sig)
+			ast.Inspect(n.Body, visit)
+			sigs = sigs[:len(sigs)-1]
+			return false
+
+		case *ast.ReturnStmt:
+			if len(sigs) == 0 || sigs[len(sigs)-1] == nil {
+				break
+			}
+			if res := sigs[len(sigs)-1].Results(); res.Len() == len(n.Results) {
+				for i, r := range n.Results {
+					set(r, res.At(i).Type())
+				}
+			}
+
+		case *ast.AssignStmt:
+			if len(n.Lhs) == len(n.Rhs) {
+				for i, r := range n.Rhs {
+					set(r, g.info.TypeOf(n.Lhs[i]))
+				}
+			}
+
+		case *ast.ValueSpec:
+			for i, v := range n.Values {
+				if i < len(n.Names) {
+					if obj := g.info.Defs[n.Names[i]]; obj != nil {
+						set(v, obj.Type())
+					}
+				}
+			}
+
+		case *ast.BinaryExpr:
+			if n.Op == token.EQL || n.Op == token.NEQ {
+				set(n.X, g.info.TypeOf(n.Y))
+				set(n.Y, g.info.TypeOf(n.X))
+			}
+
+		case *ast.SendStmt:
+			if ch, ok := underlying(g.info.TypeOf(n.Chan)).(*types.Chan); ok {
+				set(n.Value, ch.Elem())
+			}
+
+		case *ast.CallExpr:
+			sig, ok := underlying(g.info.TypeOf(n.Fun)).(*types.Signature)
+			if !ok {
+				break
+			}
+			params := sig.Params()
+			for i, a := range n.Args {
+				switch {
+				case sig.Variadic() && i >= params.Len()-1:
+					if s, ok := params.At(params.Len() - 1).Type().(*types.Slice); ok && !n.Ellipsis.IsValid() {
+						set(a, s.Elem())
+					}
+				case i < params.Len():
+					set(a, params.At(i).Type())
+				}
+			}
+
+		case *ast.CompositeLit:
+			switch t := underlying(g.info.TypeOf(n)).(type) {
+			case *types.Slice:
+				for _, e := range n.Elts {
+					if kv, ok := e.(*ast.KeyValueExpr); ok {
+						e = kv.Value
+					}
+					set(e, t.Elem())
+				}
+			case *types.Array:
+				for _, e := range n.Elts {
+					if kv, ok := e.(*ast.KeyValueExpr); ok {
+						e = kv.Value
+					}
+					set(e, t.Elem())
+				}
+			case *types.Map:
+				for _, e := range n.Elts {
+					if kv, ok := e.(*ast.KeyValueExpr); ok {
+						set(kv.Key, t.Key())
+						set(kv.Value, t.Elem())
+					}
+				}
+			case *types.Struct:
+				for i, e := range n.Elts {
+					if kv, ok := e.(*ast.KeyValueExpr); ok {
+						if obj, ok := g.info.Uses[getIdent(kv.Key)].(*types.Var); ok {
+							set(kv.Value, obj.Type())
+						}
+					} else if i < t.NumFields() {
+						set(e, t.Field(i).Type())
+					}
+				}
+			}
+		}
+		return true
+	}
+
+	for _, f := range files {
+		ast.Inspect(f, visit)
+	}
+	return out
+}
+
+func underlying(t types.Type) types.Type {
+	if t == nil {
+		return nil
+	}
+	return t.Underlying()
+}
@@ -0,0 +1,102 @@
+package genx
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/OneOfOne/xast"
+)
+
+// zeroOf returns the zero value that replaces n if it's a nil used as a placeholder that ends up
+// as a type that can't be nil (ex: `return nil` with T=int), or nil otherwise.
+func (g *GenX) zeroOf(n *ast.Ident) ast.Expr {
+	t, ok := g.nils[n]
+	if !ok {
+		return nil
+	}
+
+	src, ct := g.concreteType(t)
+	if src == types.TypeString(t, types.RelativeTo(g.pkg)) {
+		// not a placeholder
+		return nil
+	}
+
+	if ct == nil {
+		// types we can't resolve are assumed to be nillable if they look like it.
+		for _, p := range []string{"*", "[]", "map[", "chan", "<-chan", "func", "interface"} {
+			if strings.HasPrefix(src, p) {
+				return nil
+			}
+		}
+	} else {
+		switch ct.Underlying().(type) {
+		case *types.Pointer, *types.Slice, *types.Map, *types.Chan, *types.Signature, *types.Interface:
+			return nil
+		case *types.Basic:
+			if ct.Underlying() == types.Typ[types.UnsafePointer] {
+				return nil
+			}
+		}
+	}
+
+	// the placeholder's name would get rewritten again.
+	typ := ast.NewIdent(src)
+	g.visited[typ] = true
+	return g.zeroValue(typ, ct, true)
+}
+
+// zeroValue returns the zero value of typ, t is the type it ends up as or nil if it can't be resolved.
+// Constants don't need a conversion if assigned is true since the value is used as a typ already.
+func (g *GenX) zeroValue(typ ast.Expr, t types.Type, assigned bool) ast.Expr {
+	newT := &ast.StarExpr{X: &ast.CallExpr{Fun: g.universeIdent("new"), Args: []ast.Expr{typ}}}
+	if t == nil {
+		return newT
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		var lit ast.Expr
+		switch {
+		case u.Info()&types.IsBoolean != 0:
+			lit = g.universeIdent("false")
+		case u.Info()&types.IsString != 0:
+			lit = &ast.BasicLit{Kind: token.STRING, Value: `""`}
+		case u.Info()&types.IsNumeric != 0:
+			lit = &ast.BasicLit{Kind: token.INT, Value: "0"}
+		}
+		if lit == nil {
+			break
+		}
+		// untyped constants default to these already.
+		switch t {
+		case types.Typ[types.Bool], types.Typ[types.String], types.Typ[types.Int]:
+			return lit
+		}
+		if assigned {
+			return lit
+		}
+		return &ast.CallExpr{Fun: typ, Args: []ast.Expr{lit}}
+
+	case *types.Struct, *types.Array:
+		return &ast.CompositeLit{Type: typ}
+	}
+	return newT
+}
+
+// rewriteNil replaces the nils used as placeholders that can't be nil anymore with their zero value.
+func (g *GenX) rewriteNil(node *xast.Node) *xast.Node {
+	n := node.Node().(*ast.Ident)
+	x := g.zeroOf(n)
+	if x == nil {
+		return node
+	}
+	// x == T{} in an if would be parsed as a block.
+	if _, ok := x.(*ast.CompositeLit); ok {
+		if _, ok := node.Parent().Node().(*ast.BinaryExpr); ok {
+			x = &ast.ParenExpr{X: x}
+		}
+	}
+	return node.SetNode(x)
+}